@@ -4,13 +4,22 @@ import (
 	"github.com/aws/aws-cdk-go/awscdk/v2"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsapigateway"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awsdynamodb"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awsevents"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awseventstargets"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awskms"
 	"github.com/aws/aws-cdk-go/awscdk/v2/awslambda"
+	"github.com/aws/aws-cdk-go/awscdk/v2/awssecretsmanager"
 
 	// "github.com/aws/aws-cdk-go/awscdk/v2/awssqs"
 	"github.com/aws/constructs-go/constructs/v10"
 	"github.com/aws/jsii-runtime-go"
 )
 
+// defaultSchedule runs the expiry check every morning (UTC) so reminders go
+// out on the day a threshold is crossed; summaries are still sent weekly.
+// Override it with `cdk deploy -c scheduleExpression="cron(...)"`.
+const defaultSchedule = "cron(0 8 * * ? *)"
+
 type Docexpiry2StackProps struct {
 	awscdk.StackProps
 }
@@ -22,12 +31,118 @@ func NewDocexpiry2Stack(scope constructs.Construct, id string, props *Docexpiry2
 	}
 	stack := awscdk.NewStack(scope, &id, &sprops)
 
-	table := awsdynamodb.NewTable(stack, jsii.String("tokenTable"), &awsdynamodb.TableProps{
+	// Tokens are stored under a generated ID. A table's key cannot change in
+	// place and CloudFormation cannot replace a table with a fixed name, so
+	// the ID keyed table has a construct ID and name of its own. The old
+	// "Token" table is retained but no longer read: users signed in before
+	// have to sign in again, after which it can be deleted by hand.
+	table := awsdynamodb.NewTable(stack, jsii.String("userTokenTable"), &awsdynamodb.TableProps{
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("ID"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		// Tokens that are neither used nor refreshed for 30 days expire
+		TimeToLiveAttribute: jsii.String("TTL"),
+		TableName:           jsii.String("UserToken"),
+	})
+	// Looks up a user's latest token; CreatedAt is RFC 3339 so it sorts as text
+	table.AddGlobalSecondaryIndex(&awsdynamodb.GlobalSecondaryIndexProps{
+		IndexName: jsii.String("UserID-index"),
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("UserID"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		SortKey: &awsdynamodb.Attribute{
+			Name: jsii.String("CreatedAt"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+	})
+
+	spreadsheetTable := awsdynamodb.NewTable(stack, jsii.String("spreadsheetTable"), &awsdynamodb.TableProps{
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("user_id"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		SortKey: &awsdynamodb.Attribute{
+			Name: jsii.String("spreadsheet_id"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		TableName: jsii.String("Spreadsheet"),
+	})
+
+	reminderTable := awsdynamodb.NewTable(stack, jsii.String("reminderTable"), &awsdynamodb.TableProps{
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("reminder_key"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		TimeToLiveAttribute: jsii.String("expires_at"),
+		TableName:           jsii.String("Reminder"),
+	})
+
+	// Sign ins started at /login, consumed by the OAuth callback
+	oauthSessionTable := awsdynamodb.NewTable(stack, jsii.String("oauthSessionTable"), &awsdynamodb.TableProps{
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("nonce"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		TimeToLiveAttribute: jsii.String("expires_at"),
+		TableName:           jsii.String("OAuthSession"),
+	})
+
+	// One feed token per user; the index looks users up by token
+	feedTable := awsdynamodb.NewTable(stack, jsii.String("calendarFeedTable"), &awsdynamodb.TableProps{
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("user_id"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		TableName: jsii.String("CalendarFeed"),
+	})
+	feedTable.AddGlobalSecondaryIndex(&awsdynamodb.GlobalSecondaryIndexProps{
+		IndexName: jsii.String("feed_token-index"),
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("feed_token"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		ProjectionType: awsdynamodb.ProjectionType_KEYS_ONLY,
+	})
+
+	// Last result of each spreadsheet, served to the dashboard
+	resultTable := awsdynamodb.NewTable(stack, jsii.String("checkResultTable"), &awsdynamodb.TableProps{
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("user_id"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		SortKey: &awsdynamodb.Attribute{
+			Name: jsii.String("spreadsheet_id"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		TableName: jsii.String("CheckResult"),
+	})
+
+	// Documents as last seen in each spreadsheet and the history of their
+	// changes, kept for audits
+	documentTable := awsdynamodb.NewTable(stack, jsii.String("documentTable"), &awsdynamodb.TableProps{
 		PartitionKey: &awsdynamodb.Attribute{
-			Name: jsii.String("access_token"),
+			Name: jsii.String("sheet_key"),
 			Type: awsdynamodb.AttributeType_STRING,
 		},
-		TableName: jsii.String("Token"),
+		SortKey: &awsdynamodb.Attribute{
+			Name: jsii.String("id"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		TableName: jsii.String("Document"),
+	})
+
+	documentHistoryTable := awsdynamodb.NewTable(stack, jsii.String("documentHistoryTable"), &awsdynamodb.TableProps{
+		PartitionKey: &awsdynamodb.Attribute{
+			Name: jsii.String("document_key"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		SortKey: &awsdynamodb.Attribute{
+			Name: jsii.String("change_key"),
+			Type: awsdynamodb.AttributeType_STRING,
+		},
+		TableName: jsii.String("DocumentHistory"),
 	})
 
 	api := awsapigateway.NewRestApi(stack, jsii.String("docExpiryApiGateway"), &awsapigateway.RestApiProps{
@@ -52,8 +167,30 @@ func NewDocexpiry2Stack(scope constructs.Construct, id string, props *Docexpiry2
 		Runtime:awslambda.Runtime_PROVIDED_AL2023() ,
 		Code: awslambda.AssetCode_FromAsset(jsii.String("lambda/function.zip"), nil),
 		Handler: jsii.String("main"),
+		// The sign in callback reads the sheet and sends the first summary
+		// before redirecting, which takes longer than the 3 second default.
+		// API Gateway gives up on an integration after 29 seconds.
+		Timeout: awscdk.Duration_Seconds(jsii.Number(29)),
 	})
 	table.GrantReadWriteData(myFunction)
+	spreadsheetTable.GrantReadWriteData(myFunction)
+	feedTable.GrantReadWriteData(myFunction)
+	oauthSessionTable.GrantReadWriteData(myFunction)
+	resultTable.GrantReadWriteData(myFunction)
+	documentTable.GrantReadWriteData(myFunction)
+	documentHistoryTable.GrantReadWriteData(myFunction)
+
+	// Dashboard sessions are signed with a generated key, which the function
+	// reads from Secrets Manager at startup
+	sessionSigningKey := awssecretsmanager.NewSecret(stack, jsii.String("sessionSigningKey"), &awssecretsmanager.SecretProps{
+		Description: jsii.String("Key signing DocExpiry dashboard sessions"),
+		GenerateSecretString: &awssecretsmanager.SecretStringGenerator{
+			PasswordLength:     jsii.Number(64),
+			ExcludePunctuation: jsii.Bool(true),
+		},
+	})
+	sessionSigningKey.GrantRead(myFunction, nil)
+	myFunction.AddEnvironment(jsii.String("SESSION_SIGNING_KEY"), jsii.String("secretsmanager:"+*sessionSigningKey.SecretArn()), nil)
 
 	integration := awsapigateway.NewLambdaIntegration(myFunction, nil)
 	loginResource := api.Root().AddResource(jsii.String("login"), nil)
@@ -61,6 +198,153 @@ func NewDocexpiry2Stack(scope constructs.Construct, id string, props *Docexpiry2
 
 	callbackresource := api.Root().AddResource(jsii.String("oauth2callback"), nil)
 	callbackresource.AddMethod(jsii.String("GET"), integration, nil)
+
+	documentsResource := api.Root().AddResource(jsii.String("documents"), nil)
+	documentsResource.AddMethod(jsii.String("GET"), integration, nil)
+
+	summaryResource := api.Root().AddResource(jsii.String("summary"), nil)
+	summaryResource.AddMethod(jsii.String("GET"), integration, nil)
+
+	settingsResource := api.Root().AddResource(jsii.String("settings"), nil)
+	settingsResource.AddResource(jsii.String("channels"), nil).AddMethod(jsii.String("PUT"), integration, nil)
+
+	accountResource := api.Root().AddResource(jsii.String("account"), nil)
+	accountResource.AddMethod(jsii.String("DELETE"), integration, nil)
+
+	calendarResource := api.Root().AddResource(jsii.String("calendar"), nil)
+	calendarResource.AddResource(jsii.String("{feed}"), nil).AddMethod(jsii.String("GET"), integration, nil)
+
+	// Scheduled expiry checks run the same binary in scheduler mode
+	schedulerFunction := awslambda.NewFunction(stack, jsii.String("docExpirySchedulerFunc"), &awslambda.FunctionProps{
+		Runtime: awslambda.Runtime_PROVIDED_AL2023(),
+		Code:    awslambda.AssetCode_FromAsset(jsii.String("lambda/function.zip"), nil),
+		Handler: jsii.String("main"),
+		Timeout: awscdk.Duration_Minutes(jsii.Number(5)),
+		Environment: &map[string]*string{
+			"HANDLER_MODE": jsii.String("scheduler"),
+		},
+	})
+	table.GrantReadWriteData(schedulerFunction)
+	spreadsheetTable.GrantReadWriteData(schedulerFunction)
+	reminderTable.GrantReadWriteData(schedulerFunction)
+	resultTable.GrantReadWriteData(schedulerFunction)
+	documentTable.GrantReadWriteData(schedulerFunction)
+	documentHistoryTable.GrantReadWriteData(schedulerFunction)
+
+	// Stored access and refresh tokens are envelope encrypted under this key.
+	// Deleting it would make every stored token unreadable, so it is kept
+	// when the stack is removed.
+	tokenKey := awskms.NewKey(stack, jsii.String("tokenKey"), &awskms.KeyProps{
+		Description:       jsii.String("Encrypts DocExpiry OAuth tokens"),
+		EnableKeyRotation: jsii.Bool(true),
+		RemovalPolicy:     awscdk.RemovalPolicy_RETAIN,
+	})
+	for _, function := range []awslambda.Function{myFunction, schedulerFunction} {
+		tokenKey.GrantEncryptDecrypt(function)
+		function.AddEnvironment(jsii.String("TOKEN_KMS_KEY_ID"), tokenKey.KeyArn(), nil)
+	}
+
+	// The Google OAuth client is read from a secret holding a JSON object with
+	// client_id and client_secret. Without `-c googleOAuthSecretName=...` an
+	// empty secret is created; fill it in after the first deploy. The
+	// redirect URL defaults to the callback of this API; deployments behind
+	// a custom domain set `-c oauthRedirectUrl=...`.
+	var oauthClient awssecretsmanager.ISecret
+	oauthClientID := ""
+	if name, ok := stack.Node().TryGetContext(jsii.String("googleOAuthSecretName")).(string); ok && name != "" {
+		oauthClient = awssecretsmanager.Secret_FromSecretNameV2(stack, jsii.String("googleOAuthClient"), jsii.String(name))
+		oauthClientID = name
+	} else {
+		oauthClient = awssecretsmanager.NewSecret(stack, jsii.String("googleOAuthClient"), &awssecretsmanager.SecretProps{
+			Description: jsii.String("Google OAuth client of DocExpiry"),
+			SecretObjectValue: &map[string]awscdk.SecretValue{
+				"client_id":     awscdk.SecretValue_UnsafePlainText(jsii.String("")),
+				"client_secret": awscdk.SecretValue_UnsafePlainText(jsii.String("")),
+			},
+		})
+		oauthClientID = *oauthClient.SecretArn()
+	}
+	// Built from the API ID rather than its deployment URL, which would make
+	// the functions depend on the stage that depends on them
+	redirectURL := "https://" + *api.RestApiId() + ".execute-api." + *stack.Region() + "." + *stack.UrlSuffix() + "/prod/oauth2callback"
+	if value, ok := stack.Node().TryGetContext(jsii.String("oauthRedirectUrl")).(string); ok && value != "" {
+		redirectURL = value
+	}
+	for _, function := range []awslambda.Function{myFunction, schedulerFunction} {
+		oauthClient.GrantRead(function, nil)
+		function.AddEnvironment(jsii.String("GOOGLE_CLIENT_ID"), jsii.String("secretsmanager:"+oauthClientID+"#client_id"), nil)
+		function.AddEnvironment(jsii.String("GOOGLE_CLIENT_SECRET"), jsii.String("secretsmanager:"+oauthClientID+"#client_secret"), nil)
+		function.AddEnvironment(jsii.String("GOOGLE_REDIRECT_URL"), jsii.String(redirectURL), nil)
+	}
+
+	// Deployments can point the functions at their own email templates,
+	// e.g. shipped in a layer under /opt
+	if dir, ok := stack.Node().TryGetContext(jsii.String("emailTemplateDir")).(string); ok && dir != "" {
+		for _, function := range []awslambda.Function{myFunction, schedulerFunction} {
+			function.AddEnvironment(jsii.String("EMAIL_TEMPLATE_DIR"), jsii.String(dir), nil)
+		}
+	}
+
+	// Labelling sent mail needs the restricted gmail.modify scope, which
+	// Google only grants to verified apps: `cdk deploy -c gmailLabels=off`
+	// stops requesting it until the app has passed verification
+	if value, ok := stack.Node().TryGetContext(jsii.String("gmailLabels")).(string); ok && value != "" {
+		for _, function := range []awslambda.Function{myFunction, schedulerFunction} {
+			function.AddEnvironment(jsii.String("GMAIL_LABELS"), jsii.String(value), nil)
+		}
+	}
+
+	// Deployments whose users will not grant Gmail access send notifications
+	// from a shared mailbox: `cdk deploy -c smtpHost=smtp.example.com
+	// -c smtpUsername=... -c smtpFrom=...`. The password is read from a
+	// secret, like the OAuth client: `-c smtpPasswordSecretName=...` uses an
+	// existing one, otherwise a secret is created whose generated value is
+	// replaced with the mailbox password after the first deploy.
+	if host, ok := stack.Node().TryGetContext(jsii.String("smtpHost")).(string); ok && host != "" {
+		var smtpPassword awssecretsmanager.ISecret
+		smtpPasswordID := ""
+		if name, ok := stack.Node().TryGetContext(jsii.String("smtpPasswordSecretName")).(string); ok && name != "" {
+			smtpPassword = awssecretsmanager.Secret_FromSecretNameV2(stack, jsii.String("smtpPassword"), jsii.String(name))
+			smtpPasswordID = name
+		} else {
+			smtpPassword = awssecretsmanager.NewSecret(stack, jsii.String("smtpPassword"), &awssecretsmanager.SecretProps{
+				Description: jsii.String("Password of the mailbox DocExpiry sends notifications from"),
+			})
+			smtpPasswordID = *smtpPassword.SecretArn()
+		}
+
+		smtpEnv := map[string]string{
+			"EMAIL_BACKEND": "smtp",
+			"SMTP_HOST":     host,
+		}
+		for contextKey, name := range map[string]string{
+			"smtpPort":     "SMTP_PORT",
+			"smtpUsername": "SMTP_USERNAME",
+			"smtpFrom":     "SMTP_FROM",
+		} {
+			if value, ok := stack.Node().TryGetContext(jsii.String(contextKey)).(string); ok && value != "" {
+				smtpEnv[name] = value
+			}
+		}
+		for _, function := range []awslambda.Function{myFunction, schedulerFunction} {
+			for name, value := range smtpEnv {
+				function.AddEnvironment(jsii.String(name), jsii.String(value), nil)
+			}
+			smtpPassword.GrantRead(function, nil)
+			function.AddEnvironment(jsii.String("SMTP_PASSWORD"), jsii.String("secretsmanager:"+smtpPasswordID), nil)
+		}
+	}
+
+	schedule := defaultSchedule
+	if expression, ok := stack.Node().TryGetContext(jsii.String("scheduleExpression")).(string); ok && expression != "" {
+		schedule = expression
+	}
+	awsevents.NewRule(stack, jsii.String("docExpiryScheduleRule"), &awsevents.RuleProps{
+		Schedule: awsevents.Schedule_Expression(jsii.String(schedule)),
+		Targets: &[]awsevents.IRuleTarget{
+			awseventstargets.NewLambdaFunction(schedulerFunction, nil),
+		},
+	})
 	// The code that defines your stack goes here
 
 	// example resource
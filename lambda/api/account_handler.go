@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"lambda/api/auth"
+	"lambda/database"
+	"lambda/types"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// googleRevokeURL is Google's token revocation endpoint; a variable so tests
+// can point it elsewhere.
+var googleRevokeURL = "https://oauth2.googleapis.com/revoke"
+
+// AccountHandler disconnects users from DocExpiry. It runs behind the
+// session middleware rather than the token middleware, so a user can still
+// disconnect after revoking access at Google themselves.
+type AccountHandler struct {
+	databaseStore *database.DynamoDBStore
+}
+
+func NewAccountHandler(dbStore *database.DynamoDBStore) *AccountHandler {
+	return &AccountHandler{
+		databaseStore: dbStore,
+	}
+}
+
+type disconnectResponse struct {
+	GoogleRevoked        bool `json:"google_revoked"`         // Whether Google confirmed revoking access
+	TokensRevoked        int  `json:"tokens_revoked"`         // Stored tokens marked revoked
+	SpreadsheetsRemoved  int  `json:"spreadsheets_removed"`   // Spreadsheets no longer checked
+	DataDeleted          bool `json:"data_deleted"`           // Whether documents and their history were deleted
+	CalendarFeedDisabled bool `json:"calendar_feed_disabled"` // Whether the calendar feed URL stopped working
+}
+
+// DeleteAccount handles DELETE /account. It revokes the user's grant at
+// Google, marks their stored tokens revoked, stops scheduled checks of their
+// spreadsheets and disables their calendar feed. With delete_data=true the
+// cached results and stored documents with their history are deleted too.
+// It can be called again to finish a disconnect that failed halfway.
+func (ah *AccountHandler) DeleteAccount(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	corsHeaders := dashboardHeaders()
+
+	session, ok := ctx.Value("session").(*auth.SessionClaims)
+	if !ok {
+		return errorResponse(http.StatusUnauthorized, "Unauthorized: Missing user identification", corsHeaders), nil
+	}
+	userID := session.Subject
+	deleteData := request.QueryStringParameters["delete_data"] == "true"
+	response := disconnectResponse{}
+
+	// Revoke at Google first, while the stored tokens can still be read
+	tokens, err := ah.databaseStore.UserTokens(userID)
+	if err != nil {
+		fmt.Printf("reading tokens to revoke at Google failed for user %s: %v\n", userID, err)
+	} else {
+		response.GoogleRevoked = revokeGoogleTokens(userID, tokens)
+	}
+
+	if response.TokensRevoked, err = ah.databaseStore.RevokeTokens(userID); err != nil {
+		fmt.Printf("revoking tokens failed for user %s: %v\n", userID, err)
+		return errorResponse(http.StatusInternalServerError, "error disconnecting account", corsHeaders), nil
+	}
+
+	spreadsheets, err := ah.databaseStore.ListSpreadsheets(userID)
+	if err != nil {
+		fmt.Printf("listing spreadsheets failed for user %s: %v\n", userID, err)
+		return errorResponse(http.StatusInternalServerError, "error disconnecting account", corsHeaders), nil
+	}
+	for _, spreadsheet := range spreadsheets {
+		if err := ah.removeSpreadsheet(spreadsheet, deleteData); err != nil {
+			fmt.Printf("removing spreadsheet %s failed for user %s: %v\n", spreadsheet.SpreadsheetID, userID, err)
+			return errorResponse(http.StatusInternalServerError, "error disconnecting account", corsHeaders), nil
+		}
+		response.SpreadsheetsRemoved++
+	}
+	response.DataDeleted = deleteData
+
+	if err := ah.databaseStore.DeleteFeed(userID); err != nil {
+		fmt.Printf("deleting calendar feed failed for user %s: %v\n", userID, err)
+		return errorResponse(http.StatusInternalServerError, "error disconnecting account", corsHeaders), nil
+	}
+	response.CalendarFeedDisabled = true
+
+	// Sign the user out
+	corsHeaders["Set-Cookie"] = auth.ClearSessionCookie()
+	return jsonResponse(response, corsHeaders), nil
+}
+
+// Helper function to stop checking a spreadsheet, deleting what was stored
+// about it when asked to. The registration is removed last, so a failed
+// attempt can be repeated.
+func (ah *AccountHandler) removeSpreadsheet(spreadsheet *types.Spreadsheet, deleteData bool) error {
+	if deleteData {
+		if err := ah.databaseStore.DeleteDocuments(spreadsheet.UserID, spreadsheet.SpreadsheetID); err != nil {
+			return err
+		}
+		if err := ah.databaseStore.DeleteResult(spreadsheet.UserID, spreadsheet.SpreadsheetID); err != nil {
+			return err
+		}
+	}
+	return ah.databaseStore.DeleteSpreadsheet(spreadsheet.UserID, spreadsheet.SpreadsheetID)
+}
+
+// Helper function to revoke every stored grant of a user at Google. Sign ins
+// may have stored different refresh tokens, and each stays valid until
+// revoked. It reports whether Google confirmed revoking all of them.
+func revokeGoogleTokens(userID string, tokens []*types.Token) bool {
+	if len(tokens) == 0 {
+		fmt.Printf("no token to revoke at Google for user %s\n", userID)
+		return false
+	}
+	revoked := true
+	seen := map[string]bool{}
+	for _, token := range tokens {
+		value := revokableToken(token)
+		if seen[value] {
+			continue
+		}
+		seen[value] = true
+		if err := revokeGoogleToken(token); err != nil {
+			fmt.Printf("revoking Google access of token %s failed for user %s: %v\n", token.ID, userID, err)
+			revoked = false
+		}
+	}
+	return revoked
+}
+
+// Helper function to revoke a grant at Google. Revoking the refresh
+// token also revokes the access tokens issued from it. A token Google no
+// longer knows counts as revoked.
+func revokeGoogleToken(token *types.Token) error {
+	value := revokableToken(token)
+	if value == "" {
+		return fmt.Errorf("token has neither a refresh nor an access token")
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(googleRevokeURL, "application/x-www-form-urlencoded",
+		strings.NewReader(url.Values{"token": {value}}.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var revokeErr struct {
+		Error string `json:"error"`
+	}
+	if resp.StatusCode == http.StatusBadRequest && json.Unmarshal(body, &revokeErr) == nil && revokeErr.Error == "invalid_token" {
+		return nil
+	}
+	return fmt.Errorf("revoke returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
+
+// Helper function to pick the token to revoke, preferring the refresh token.
+func revokableToken(token *types.Token) string {
+	if token.RefreshToken != "" {
+		return token.RefreshToken
+	}
+	return token.AccessToken
+}
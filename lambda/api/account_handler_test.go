@@ -0,0 +1,157 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"lambda/api/auth"
+	"lambda/database"
+	"lambda/types"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// stubGoogleRevoke points googleRevokeURL at a server recording the revoked
+// tokens and answering each with respond.
+func stubGoogleRevoke(t *testing.T, respond func(w http.ResponseWriter, token string)) *[]string {
+	t.Helper()
+	var mu sync.Mutex
+	var revoked []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		token := r.PostFormValue("token")
+		revoked = append(revoked, token)
+		respond(w, token)
+	}))
+	t.Cleanup(server.Close)
+
+	previous := googleRevokeURL
+	googleRevokeURL = server.URL
+	t.Cleanup(func() { googleRevokeURL = previous })
+	return &revoked
+}
+
+func TestDeleteAccount(t *testing.T) {
+	tests := []struct {
+		name        string
+		respond     func(w http.ResponseWriter, token string)
+		wantRevoked bool
+	}{
+		{
+			name: "Google revokes every grant",
+			respond: func(w http.ResponseWriter, token string) {
+				if token == "refresh-2" {
+					// Already revoked by the user at Google
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(`{"error":"invalid_token"}`))
+				}
+			},
+			wantRevoked: true,
+		},
+		{
+			name: "Google fails to revoke a grant",
+			respond: func(w http.ResponseWriter, token string) {
+				if token == "refresh-1" {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbStore, fake := newTestStore(t)
+			store := func(userID, accessToken, refreshToken string) {
+				t.Helper()
+				token := &types.Token{UserID: userID, AccessToken: accessToken, RefreshToken: refreshToken, Expiry: time.Now().Add(time.Hour)}
+				if err := dbStore.StoreToken(token); err != nil {
+					t.Fatalf("StoreToken: %v", err)
+				}
+			}
+			store("user-1", "access-0", "refresh-0")
+			if _, err := dbStore.RevokeTokens("user-1"); err != nil {
+				t.Fatalf("RevokeTokens: %v", err)
+			}
+			store("user-1", "access-1", "refresh-1")
+			store("user-1", "access-2", "refresh-2")
+			store("user-1", "access-3", "refresh-2") // Refresh token carried forward
+			store("user-1", "access-4", "")
+			store("user-2", "access-x", "refresh-x")
+
+			spreadsheet := &types.Spreadsheet{UserID: "user-1", SheetSettings: types.SheetSettings{SpreadsheetID: "sheet-1"}}
+			if err := dbStore.CreateSpreadsheet(spreadsheet); err != nil {
+				t.Fatalf("CreateSpreadsheet: %v", err)
+			}
+			if err := dbStore.SaveResult(&types.CheckResult{UserID: "user-1", SpreadsheetID: "sheet-1"}); err != nil {
+				t.Fatalf("SaveResult: %v", err)
+			}
+			if _, err := NewDocumentRecorder(dbStore).Record(spreadsheet, []*types.Document{{ID: "passport", DocumentName: "Passport"}}, time.Now()); err != nil {
+				t.Fatalf("Record: %v", err)
+			}
+			if _, err := dbStore.FeedToken("user-1"); err != nil {
+				t.Fatalf("FeedToken: %v", err)
+			}
+
+			revoked := stubGoogleRevoke(t, tt.respond)
+			ctx := context.WithValue(context.Background(), "session", &auth.SessionClaims{Subject: "user-1"})
+			response, err := NewAccountHandler(dbStore).DeleteAccount(ctx, events.APIGatewayProxyRequest{
+				QueryStringParameters: map[string]string{"delete_data": "true"},
+			})
+			if err != nil {
+				t.Fatalf("DeleteAccount: %v", err)
+			}
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d: %s", response.StatusCode, response.Body)
+			}
+
+			// Each distinct grant is revoked once, before the tokens are removed
+			sort.Strings(*revoked)
+			if want := []string{"access-4", "refresh-1", "refresh-2"}; strings.Join(*revoked, ",") != strings.Join(want, ",") {
+				t.Errorf("revoked at Google = %v, want %v", *revoked, want)
+			}
+
+			var body disconnectResponse
+			if err := json.Unmarshal([]byte(response.Body), &body); err != nil {
+				t.Fatalf("decoding %s: %v", response.Body, err)
+			}
+			want := disconnectResponse{GoogleRevoked: tt.wantRevoked, TokensRevoked: 4, SpreadsheetsRemoved: 1, DataDeleted: true, CalendarFeedDisabled: true}
+			if body != want {
+				t.Errorf("response = %+v, want %+v", body, want)
+			}
+			if !strings.Contains(response.Headers["Set-Cookie"], auth.SessionCookieName+"=;") {
+				t.Errorf("Set-Cookie = %q, want the session cleared", response.Headers["Set-Cookie"])
+			}
+
+			if _, err := dbStore.LatestToken("user-1"); !errors.Is(err, database.ErrTokenNotFound) {
+				t.Errorf("LatestToken after disconnecting = %v, want %v", err, database.ErrTokenNotFound)
+			}
+			if _, err := dbStore.LatestToken("user-2"); err != nil {
+				t.Errorf("token of another user: %v", err)
+			}
+			for _, table := range []string{database.SPREADSHEET_TABLE_NAME, database.RESULT_TABLE_NAME, database.DOCUMENT_TABLE_NAME, database.DOCUMENT_HISTORY_TABLE_NAME, database.FEED_TABLE_NAME} {
+				if items := fake.Items(table); len(items) != 0 {
+					t.Errorf("%d items left in %s", len(items), table)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteAccountRequiresSession(t *testing.T) {
+	dbStore, _ := newTestStore(t)
+	revoked := stubGoogleRevoke(t, func(http.ResponseWriter, string) {})
+	response, err := NewAccountHandler(dbStore).DeleteAccount(context.Background(), events.APIGatewayProxyRequest{})
+	if err != nil {
+		t.Fatalf("DeleteAccount: %v", err)
+	}
+	if response.StatusCode != http.StatusUnauthorized || len(*revoked) != 0 {
+		t.Errorf("status = %d with %d revocations, want %d and none", response.StatusCode, len(*revoked), http.StatusUnauthorized)
+	}
+}
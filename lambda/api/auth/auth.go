@@ -1,6 +1,11 @@
 package auth
 
 import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 )
@@ -12,23 +17,73 @@ type AuthConfig struct{
 	Scopes []string
 }
 
-//https://spwzll7jm5.execute-api.eu-north-1.amazonaws.com/prod/oauth2callback
-
-func NewAuthConfig() *AuthConfig{
-	return &AuthConfig{
-		ClientID:     "384543079988-lillmo592a40vt43dg3etuf3ghbg8s74.apps.googleusercontent.com",
-        ClientSecret: "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",
-        RedirectURL:  "https://spwzll7jm5.execute-api.eu-north-1.amazonaws.com/prod/oauth2callback",
-        Scopes: []string{
-            "https://www.googleapis.com/auth/userinfo.profile",
-            "https://www.googleapis.com/auth/userinfo.email",
-            "https://www.googleapis.com/auth/spreadsheets",
+// LoadAuthConfig reads the Google OAuth client from GOOGLE_CLIENT_ID,
+// GOOGLE_CLIENT_SECRET and GOOGLE_REDIRECT_URL, each of which may refer to a
+// Secrets Manager secret or SSM parameter (see ConfigValue). It fails when
+// any of them is missing, so a misconfigured deployment fails at startup
+// rather than on the first sign in.
+func LoadAuthConfig() (*AuthConfig, error) {
+	values := map[string]string{}
+	var missing []string
+	for _, name := range []string{"GOOGLE_CLIENT_ID", "GOOGLE_CLIENT_SECRET", "GOOGLE_REDIRECT_URL"} {
+		value, err := ConfigValue(name)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			missing = append(missing, name)
+		}
+		values[name] = value
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing OAuth configuration: %s", strings.Join(missing, ", "))
+	}
+
+	redirectURL, err := url.Parse(values["GOOGLE_REDIRECT_URL"])
+	if err != nil || redirectURL.Host == "" || (redirectURL.Scheme != "https" && redirectURL.Hostname() != "localhost") {
+		return nil, fmt.Errorf("GOOGLE_REDIRECT_URL must be an https URL, got %q", values["GOOGLE_REDIRECT_URL"])
+	}
+
+	config := &AuthConfig{
+		ClientID:     values["GOOGLE_CLIENT_ID"],
+		ClientSecret: values["GOOGLE_CLIENT_SECRET"],
+		RedirectURL:  redirectURL.String(),
+		Scopes: []string{
+			"https://www.googleapis.com/auth/userinfo.profile",
+			"https://www.googleapis.com/auth/userinfo.email",
+			"https://www.googleapis.com/auth/spreadsheets",
 			"https://www.googleapis.com/auth/spreadsheets.readonly",
-			"https://www.googleapis.com/auth/gmail.send",
-			"https://www.googleapis.com/auth/gmail.labels",
 			"https://www.googleapis.com/auth/calendar.events",
-        },
+		},
 	}
+	if !strings.EqualFold(os.Getenv("EMAIL_BACKEND"), "smtp") {
+		config.Scopes = append(config.Scopes, GmailSendScope)
+		if !strings.EqualFold(os.Getenv("GMAIL_LABELS"), "off") {
+			config.Scopes = append(config.Scopes, gmailLabelScopes...)
+		}
+	}
+	return config, nil
+}
+
+// Gmail scopes are only requested when notifications are sent through the
+// user's Gmail account rather than a shared SMTP mailbox.
+//
+// gmail.modify, needed to label sent messages and read back their Message-ID
+// for threading, is a restricted scope: an app published to users outside
+// its Google Cloud organisation must pass Google's OAuth verification and an
+// annual third party security assessment before it may request it, and is
+// limited to 100 test users until then. Deployments that cannot be verified
+// set GMAIL_LABELS=off, or EMAIL_BACKEND=smtp to avoid Gmail altogether.
+// Users can also decline the scope on the consent screen, so the scopes a
+// token was actually granted are checked before any call needing them.
+const (
+	GmailSendScope   = "https://www.googleapis.com/auth/gmail.send"
+	GmailModifyScope = "https://www.googleapis.com/auth/gmail.modify"
+)
+
+var gmailLabelScopes = []string{
+	"https://www.googleapis.com/auth/gmail.labels",
+	GmailModifyScope,
 }
 
 
@@ -40,4 +95,21 @@ func (ac *AuthConfig) ToOAuth2Config() *oauth2.Config {
         Scopes:       ac.Scopes,
         Endpoint:     google.Endpoint,
     }
-}
\ No newline at end of file
+}
+// GrantedScope returns the space separated scopes Google reported granting
+// with a token, or "" when the token response did not include them.
+func GrantedScope(token *oauth2.Token) string {
+	scope, _ := token.Extra("scope").(string)
+	return scope
+}
+
+// HasScope reports whether a space separated list of granted scopes
+// includes the given scope.
+func HasScope(granted, scope string) bool {
+	for _, s := range strings.Fields(granted) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// Prefixes of configuration values stored outside the environment:
+//
+//	secretsmanager:<secret id>[#<json key>]
+//	ssm:<parameter name>
+//
+// A JSON key selects one field of a secret holding a JSON object, so one
+// secret can hold both the client ID and secret.
+const (
+	secretsManagerPrefix = "secretsmanager:"
+	ssmPrefix            = "ssm:"
+)
+
+var (
+	awsSessionOnce sync.Once
+	awsSession     *session.Session
+	awsSessionErr  error
+
+	// Secrets are fetched once per container, even when several values
+	// refer to the same secret
+	resolvedMu sync.Mutex
+	resolved   = map[string]string{}
+)
+
+// ConfigValue returns the value of an environment variable, fetching it from
+// Secrets Manager or SSM Parameter Store when the variable holds a
+// reference. Unset variables return an empty string.
+func ConfigValue(name string) (string, error) {
+	value := strings.TrimSpace(os.Getenv(name))
+	switch {
+	case strings.HasPrefix(value, secretsManagerPrefix):
+		resolvedValue, err := secretValue(strings.TrimPrefix(value, secretsManagerPrefix))
+		if err != nil {
+			return "", fmt.Errorf("error resolving %s: %w", name, err)
+		}
+		return resolvedValue, nil
+	case strings.HasPrefix(value, ssmPrefix):
+		resolvedValue, err := parameterValue(strings.TrimPrefix(value, ssmPrefix))
+		if err != nil {
+			return "", fmt.Errorf("error resolving %s: %w", name, err)
+		}
+		return resolvedValue, nil
+	default:
+		return value, nil
+	}
+}
+
+// secretValue fetches a secret, or one field of a JSON secret when the
+// reference ends in #<json key>.
+func secretValue(reference string) (string, error) {
+	secretID, key, _ := strings.Cut(reference, "#")
+	secretString, err := cachedValue(secretsManagerPrefix+secretID, func(sess *session.Session) (string, error) {
+		output, err := secretsmanager.New(sess).GetSecretValue(&secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secretID),
+		})
+		if err != nil {
+			return "", err
+		}
+		return aws.StringValue(output.SecretString), nil
+	})
+	if err != nil || key == "" {
+		return secretString, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secretString), &fields); err != nil {
+		return "", fmt.Errorf("secret %s is not a JSON object", secretID)
+	}
+	field, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no %q field", secretID, key)
+	}
+	text, ok := field.(string)
+	if !ok {
+		return "", fmt.Errorf("field %q of secret %s is not a string", key, secretID)
+	}
+	return text, nil
+}
+
+// parameterValue fetches an SSM parameter, decrypting SecureString ones.
+func parameterValue(name string) (string, error) {
+	return cachedValue(ssmPrefix+name, func(sess *session.Session) (string, error) {
+		output, err := ssm.New(sess).GetParameter(&ssm.GetParameterInput{
+			Name:           aws.String(name),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return "", err
+		}
+		return aws.StringValue(output.Parameter.Value), nil
+	})
+}
+
+func cachedValue(reference string, fetch func(*session.Session) (string, error)) (string, error) {
+	resolvedMu.Lock()
+	defer resolvedMu.Unlock()
+	if value, ok := resolved[reference]; ok {
+		return value, nil
+	}
+
+	awsSessionOnce.Do(func() {
+		awsSession, awsSessionErr = session.NewSession()
+	})
+	if awsSessionErr != nil {
+		return "", awsSessionErr
+	}
+	value, err := fetch(awsSession)
+	if err != nil {
+		return "", err
+	}
+	resolved[reference] = value
+	return value, nil
+}
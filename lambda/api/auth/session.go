@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// SessionCookieName is the cookie holding the session token.
+	SessionCookieName = "docexpiry_session"
+
+	sessionIssuer = "docexpiry"
+
+	// A session token is valid for SessionTTL and is rotated on use once
+	// it is older than sessionRotateAfter. Rotation keeps an active user
+	// signed in, but never beyond sessionMaxAge after they signed in.
+	SessionTTL         = 12 * time.Hour
+	sessionRotateAfter = time.Hour
+	sessionMaxAge      = 7 * 24 * time.Hour
+
+	minSessionKeyLength = 32
+)
+
+var (
+	ErrInvalidSession = errors.New("invalid session")
+	ErrSessionExpired = errors.New("session expired")
+)
+
+// SessionClaims are the claims of a session token, a JWT signed with
+// HMAC-SHA256.
+type SessionClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"` // Google user ID
+	Email     string `json:"email,omitempty"`
+	ID        string `json:"jti"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	AuthTime  int64  `json:"auth_time"` // When the user signed in with Google
+}
+
+// NeedsRotation reports whether a fresh token should replace this one.
+func (c *SessionClaims) NeedsRotation(now time.Time) bool {
+	return now.Sub(time.Unix(c.IssuedAt, 0)) >= sessionRotateAfter
+}
+
+// SessionSigner issues and verifies session tokens.
+type SessionSigner struct {
+	key []byte
+}
+
+func NewSessionSigner(key []byte) (*SessionSigner, error) {
+	if len(key) < minSessionKeyLength {
+		return nil, fmt.Errorf("session signing key must be at least %d bytes", minSessionKeyLength)
+	}
+	return &SessionSigner{key: key}, nil
+}
+
+// SessionSignerFromEnv uses the key in SESSION_SIGNING_KEY, which may refer
+// to a Secrets Manager secret or SSM parameter (see ConfigValue).
+func SessionSignerFromEnv() (*SessionSigner, error) {
+	key, err := ConfigValue("SESSION_SIGNING_KEY")
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return nil, errors.New("SESSION_SIGNING_KEY is not set")
+	}
+	return NewSessionSigner([]byte(key))
+}
+
+// Issue creates a session token for a user who just signed in.
+func (ss *SessionSigner) Issue(userID, email string, now time.Time) (string, *SessionClaims, error) {
+	return ss.issue(&SessionClaims{
+		Subject:  userID,
+		Email:    email,
+		AuthTime: now.Unix(),
+	}, now)
+}
+
+// Rotate replaces a valid token with a new one for the same sign-in. It
+// fails once the sign-in is older than the maximum session age.
+func (ss *SessionSigner) Rotate(claims *SessionClaims, now time.Time) (string, *SessionClaims, error) {
+	if now.Sub(time.Unix(claims.AuthTime, 0)) >= sessionMaxAge {
+		return "", nil, ErrSessionExpired
+	}
+	return ss.issue(&SessionClaims{
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+		AuthTime: claims.AuthTime,
+	}, now)
+}
+
+func (ss *SessionSigner) issue(claims *SessionClaims, now time.Time) (string, *SessionClaims, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, fmt.Errorf("error generating session id: %w", err)
+	}
+
+	claims.Issuer = sessionIssuer
+	claims.ID = hex.EncodeToString(id)
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(SessionTTL).Unix()
+	if maxExpiry := time.Unix(claims.AuthTime, 0).Add(sessionMaxAge); maxExpiry.Before(now.Add(SessionTTL)) {
+		claims.ExpiresAt = maxExpiry.Unix()
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, fmt.Errorf("error encoding session: %w", err)
+	}
+	signingInput := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signingInput + "." + ss.sign(signingInput), claims, nil
+}
+
+// Verify checks a session token's signature and expiry and returns its
+// claims.
+func (ss *SessionSigner) Verify(token string, now time.Time) (*SessionClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return nil, ErrInvalidSession
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	expected, _ := base64.RawURLEncoding.DecodeString(ss.sign(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, expected) {
+		return nil, ErrInvalidSession
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	var claims SessionClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidSession
+	}
+	if claims.Issuer != sessionIssuer || claims.Subject == "" {
+		return nil, ErrInvalidSession
+	}
+	if !now.Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, ErrSessionExpired
+	}
+	return &claims, nil
+}
+
+func (ss *SessionSigner) sign(signingInput string) string {
+	mac := hmac.New(sha256.New, ss.key)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// jwtHeader is the encoded header of every token we issue; tokens with any
+// other header, such as "alg":"none", are rejected.
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// SessionCookie returns the Set-Cookie header value carrying a session
+// token. The dashboard runs on another origin, so the cookie has to be
+// SameSite=None, which browsers only accept together with Secure.
+func SessionCookie(token string, claims *SessionClaims) string {
+	cookie := &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Unix(claims.ExpiresAt, 0),
+		MaxAge:   int(time.Until(time.Unix(claims.ExpiresAt, 0)).Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	}
+	return cookie.String()
+}
+
+// ClearSessionCookie returns the Set-Cookie header value removing the
+// session cookie.
+func ClearSessionCookie() string {
+	cookie := &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	}
+	return cookie.String()
+}
+
+// SessionTokenFromHeaders returns the session token sent as a cookie or, for
+// clients other than browsers, as a bearer token.
+func SessionTokenFromHeaders(headers map[string]string, multiValueHeaders map[string][]string) string {
+	header := http.Header{}
+	for name, values := range multiValueHeaders {
+		for _, value := range values {
+			header.Add(name, value)
+		}
+	}
+	for name, value := range headers {
+		if len(header.Values(name)) == 0 {
+			header.Add(name, value)
+		}
+	}
+
+	if authorization := header.Get("Authorization"); strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+	if cookie, err := (&http.Request{Header: header}).Cookie(SessionCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSessionKey = []byte(strings.Repeat("k", minSessionKeyLength))
+
+// forgeSession builds a token with any header and claims, signed with key.
+func forgeSession(t *testing.T, key []byte, header string, claims *SessionClaims) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("encoding claims: %v", err)
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySession(t *testing.T) {
+	signer, err := NewSessionSigner(testSessionKey)
+	if err != nil {
+		t.Fatalf("NewSessionSigner: %v", err)
+	}
+	otherSigner, _ := NewSessionSigner([]byte(strings.Repeat("x", minSessionKeyLength)))
+	signedIn := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+	token, _, err := signer.Issue("user-1", "ann@example.com", signedIn)
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	claims := func() *SessionClaims {
+		return &SessionClaims{Issuer: sessionIssuer, Subject: "user-1", ID: "id", IssuedAt: signedIn.Unix(), ExpiresAt: signedIn.Add(SessionTTL).Unix(), AuthTime: signedIn.Unix()}
+	}
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+
+	tests := []struct {
+		name    string
+		token   func() string
+		now     time.Time
+		wantErr error
+	}{
+		{
+			name:  "valid",
+			token: func() string { return token },
+			now:   signedIn.Add(time.Hour),
+		},
+		{
+			name:  "forged with the signing key",
+			token: func() string { return forgeSession(t, testSessionKey, hs256, claims()) },
+			now:   signedIn,
+		},
+		{
+			name: "tampered signature",
+			token: func() string {
+				replacement := "A"
+				if token[len(token)-5:len(token)-4] == replacement {
+					replacement = "B"
+				}
+				return token[:len(token)-5] + replacement + token[len(token)-4:]
+			},
+			now:     signedIn,
+			wantErr: ErrInvalidSession,
+		},
+		{
+			name: "tampered claims",
+			token: func() string {
+				parts := strings.Split(token, ".")
+				c := claims()
+				c.Subject = "user-2"
+				forged := strings.Split(forgeSession(t, []byte("unused"), hs256, c), ".")
+				return parts[0] + "." + forged[1] + "." + parts[2]
+			},
+			now:     signedIn,
+			wantErr: ErrInvalidSession,
+		},
+		{
+			name: "alg none",
+			token: func() string {
+				forged := forgeSession(t, testSessionKey, `{"alg":"none","typ":"JWT"}`, claims())
+				return forged[:strings.LastIndex(forged, ".")+1]
+			},
+			now:     signedIn,
+			wantErr: ErrInvalidSession,
+		},
+		{
+			name:    "alg none signed with the key",
+			token:   func() string { return forgeSession(t, testSessionKey, `{"alg":"none","typ":"JWT"}`, claims()) },
+			now:     signedIn,
+			wantErr: ErrInvalidSession,
+		},
+		{
+			name:    "alg RS256",
+			token:   func() string { return forgeSession(t, testSessionKey, `{"alg":"RS256","typ":"JWT"}`, claims()) },
+			now:     signedIn,
+			wantErr: ErrInvalidSession,
+		},
+		{
+			name: "wrong key",
+			token: func() string {
+				other, _, _ := otherSigner.Issue("user-1", "ann@example.com", signedIn)
+				return other
+			},
+			now:     signedIn,
+			wantErr: ErrInvalidSession,
+		},
+		{
+			name: "missing sub",
+			token: func() string {
+				c := claims()
+				c.Subject = ""
+				return forgeSession(t, testSessionKey, hs256, c)
+			},
+			now:     signedIn,
+			wantErr: ErrInvalidSession,
+		},
+		{
+			name: "other issuer",
+			token: func() string {
+				c := claims()
+				c.Issuer = "someone-else"
+				return forgeSession(t, testSessionKey, hs256, c)
+			},
+			now:     signedIn,
+			wantErr: ErrInvalidSession,
+		},
+		{
+			name:    "not a JWT",
+			token:   func() string { return "not-a-token" },
+			now:     signedIn,
+			wantErr: ErrInvalidSession,
+		},
+		{
+			name:  "a second before expiry",
+			token: func() string { return token },
+			now:   signedIn.Add(SessionTTL - time.Second),
+		},
+		{
+			name:    "expired",
+			token:   func() string { return token },
+			now:     signedIn.Add(SessionTTL),
+			wantErr: ErrSessionExpired,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := signer.Verify(tt.token(), tt.now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got.Subject != "user-1" {
+				t.Errorf("subject = %q, want user-1", got.Subject)
+			}
+		})
+	}
+}
+
+func TestRotateSession(t *testing.T) {
+	signer, _ := NewSessionSigner(testSessionKey)
+	signedIn := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		issuedAt     time.Time // Of the token being rotated
+		now          time.Time
+		wantRotation bool
+		wantErr      error
+		wantExpiry   time.Time
+	}{
+		{
+			name:     "just before the rotation window",
+			issuedAt: signedIn,
+			now:      signedIn.Add(sessionRotateAfter - time.Second),
+		},
+		{
+			name:         "start of the rotation window",
+			issuedAt:     signedIn,
+			now:          signedIn.Add(sessionRotateAfter),
+			wantRotation: true,
+			wantExpiry:   signedIn.Add(sessionRotateAfter + SessionTTL),
+		},
+		{
+			name:         "rotated again days later",
+			issuedAt:     signedIn.Add(5 * 24 * time.Hour),
+			now:          signedIn.Add(5*24*time.Hour + 2*time.Hour),
+			wantRotation: true,
+			wantExpiry:   signedIn.Add(5*24*time.Hour + 2*time.Hour + SessionTTL),
+		},
+		{
+			name:         "rotation capped at the maximum age",
+			issuedAt:     signedIn.Add(sessionMaxAge - 3*time.Hour),
+			now:          signedIn.Add(sessionMaxAge - time.Hour),
+			wantRotation: true,
+			wantExpiry:   signedIn.Add(sessionMaxAge),
+		},
+		{
+			name:         "maximum age exceeded",
+			issuedAt:     signedIn.Add(sessionMaxAge - 2*time.Hour),
+			now:          signedIn.Add(sessionMaxAge),
+			wantRotation: true,
+			wantErr:      ErrSessionExpired,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &SessionClaims{Subject: "user-1", Email: "ann@example.com", IssuedAt: tt.issuedAt.Unix(), AuthTime: signedIn.Unix()}
+			if got := claims.NeedsRotation(tt.now); got != tt.wantRotation {
+				t.Errorf("NeedsRotation = %v, want %v", got, tt.wantRotation)
+			}
+			if !tt.wantRotation {
+				return
+			}
+
+			token, rotated, err := signer.Rotate(claims, tt.now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Rotate error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if rotated.AuthTime != signedIn.Unix() || rotated.IssuedAt != tt.now.Unix() {
+				t.Errorf("rotated claims = %+v, want the sign in time kept and issued now", rotated)
+			}
+			if got := time.Unix(rotated.ExpiresAt, 0); !got.Equal(tt.wantExpiry) {
+				t.Errorf("expires at %v, want %v", got.UTC(), tt.wantExpiry)
+			}
+
+			// The rotated token never outlives the maximum age
+			if _, err := signer.Verify(token, tt.now); err != nil {
+				t.Errorf("Verify rotated token: %v", err)
+			}
+			if _, err := signer.Verify(token, signedIn.Add(sessionMaxAge)); !errors.Is(err, ErrSessionExpired) {
+				t.Errorf("Verify at the maximum age = %v, want %v", err, ErrSessionExpired)
+			}
+		})
+	}
+}
+
+func TestNewSessionSignerKeyLength(t *testing.T) {
+	if _, err := NewSessionSigner(testSessionKey[:minSessionKeyLength-1]); err == nil {
+		t.Error("NewSessionSigner accepted a short key")
+	}
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"lambda/database"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// iCalendar lines may not exceed 75 octets before folding.
+const icsLineLimit = 75
+
+// CalendarFeedHandler serves each user's documents as an iCalendar feed that
+// calendar apps can subscribe to without write access to their calendar.
+type CalendarFeedHandler struct {
+	databaseStore *database.DynamoDBStore
+}
+
+func NewCalendarFeedHandler(dbStore *database.DynamoDBStore) *CalendarFeedHandler {
+	return &CalendarFeedHandler{
+		databaseStore: dbStore,
+	}
+}
+
+// ServeFeed handles GET /calendar/{feedToken}.ics.
+func (fh *CalendarFeedHandler) ServeFeed(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	headers := map[string]string{
+		"Content-Type": "text/plain; charset=utf-8",
+	}
+
+	feedToken := request.PathParameters["feed"]
+	if feedToken == "" {
+		feedToken = path.Base(request.Path)
+	}
+	if !strings.HasSuffix(feedToken, ".ics") {
+		return errorResponse(http.StatusNotFound, "calendar feed not found", headers), nil
+	}
+
+	userID, err := fh.databaseStore.FeedUser(strings.TrimSuffix(feedToken, ".ics"))
+	if errors.Is(err, database.ErrFeedNotFound) {
+		return errorResponse(http.StatusNotFound, "calendar feed not found", headers), nil
+	}
+	if err != nil {
+		fmt.Printf("calendar feed lookup failed: %v\n", err)
+		return errorResponse(http.StatusInternalServerError, "error loading calendar feed", headers), nil
+	}
+
+	now := time.Now()
+	results, err := currentResults(fh.databaseStore, userID, now)
+	if err != nil {
+		fmt.Printf("calendar feed failed for user %s: %v\n", userID, err)
+		return errorResponse(http.StatusInternalServerError, "error loading calendar feed", headers), nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers: map[string]string{
+			"Content-Type":        "text/calendar; charset=utf-8",
+			"Content-Disposition": `inline; filename="docexpiry.ics"`,
+			"Cache-Control":       "private, max-age=900",
+		},
+		Body: renderCalendarFeed(results, now),
+	}, nil
+}
+
+// renderCalendarFeed renders one all-day VEVENT per document on its expiry
+// date, with a display alarm for each calendar reminder day. Documents come
+// from the last check of each spreadsheet.
+func renderCalendarFeed(results []*cachedResult, now time.Time) string {
+	var ics strings.Builder
+	writeLine := func(name, value string) {
+		ics.WriteString(foldICSLine(name + ":" + value))
+	}
+
+	writeLine("BEGIN", "VCALENDAR")
+	writeLine("VERSION", "2.0")
+	writeLine("PRODID", "-//DocExpiry//Document Expiry Feed//EN")
+	writeLine("CALSCALE", "GREGORIAN")
+	writeLine("METHOD", "PUBLISH")
+	writeLine("X-WR-CALNAME", "Document Expiries")
+	writeLine("REFRESH-INTERVAL;VALUE=DURATION", "PT12H")
+	writeLine("X-PUBLISHED-TTL", "PT12H")
+
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, cached := range results {
+		spreadsheetID := cached.Spreadsheet.SpreadsheetID
+		calendarSync := NewCalendarSync(nil, &cached.Spreadsheet.Calendar)
+		for _, doc := range cached.Result.Documents {
+			writeLine("BEGIN", "VEVENT")
+			writeLine("UID", calendarEventID(spreadsheetID, doc.ID)+"@docexpiry")
+			writeLine("DTSTAMP", stamp)
+			writeLine("DTSTART;VALUE=DATE", doc.ExpiryDate.Format("20060102"))
+			writeLine("DTEND;VALUE=DATE", doc.ExpiryDate.AddDate(0, 0, 1).Format("20060102"))
+			writeLine("SUMMARY", escapeICSText(eventSummary(doc)))
+			writeLine("DESCRIPTION", escapeICSText(eventDescription(spreadsheetID, doc)))
+			writeLine("URL", spreadsheetURL(spreadsheetID))
+			writeLine("CATEGORIES", escapeICSText(doc.Status))
+			writeLine("TRANSP", "TRANSPARENT")
+			for _, minutes := range calendarSync.reminderMinutes() {
+				writeLine("BEGIN", "VALARM")
+				writeLine("ACTION", "DISPLAY")
+				writeLine("DESCRIPTION", escapeICSText(eventSummary(doc)))
+				writeLine("TRIGGER", icsDuration(minutes))
+				writeLine("END", "VALARM")
+			}
+			writeLine("END", "VEVENT")
+		}
+	}
+
+	writeLine("END", "VCALENDAR")
+	return ics.String()
+}
+
+// icsDuration renders a trigger the given number of minutes before the
+// event, e.g. "-P7D" or "-PT0M".
+func icsDuration(minutes int64) string {
+	if minutes == 0 {
+		return "-PT0M"
+	}
+	if minutes%(24*60) == 0 {
+		return fmt.Sprintf("-P%dD", minutes/(24*60))
+	}
+	return fmt.Sprintf("-PT%dM", minutes)
+}
+
+// escapeICSText escapes a TEXT value.
+func escapeICSText(text string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(text)
+}
+
+// foldICSLine terminates a content line, folding it into continuation lines
+// of at most 75 octets without splitting UTF-8 characters.
+func foldICSLine(line string) string {
+	var folded strings.Builder
+	limit := icsLineLimit
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !isUTF8Start(line[cut]) {
+			cut--
+		}
+		folded.WriteString(line[:cut])
+		folded.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space, which counts against the limit
+		limit = icsLineLimit - 1
+	}
+	folded.WriteString(line)
+	folded.WriteString("\r\n")
+	return folded.String()
+}
+
+func isUTF8Start(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+// calendarFeedURL builds the address of the user's calendar feed, served by
+// the same API. It returns "" when the feed token cannot be read.
+func calendarFeedURL(dbStore *database.DynamoDBStore, request events.APIGatewayProxyRequest, userID string) string {
+	feedToken, err := dbStore.FeedToken(userID)
+	if err != nil {
+		fmt.Printf("failed to get calendar feed token: %v\n", err)
+		return ""
+	}
+	host := request.RequestContext.DomainName
+	if host == "" {
+		host = request.Headers["Host"]
+	}
+	return fmt.Sprintf("https://%s/%s/calendar/%s.ics", host, request.RequestContext.Stage, feedToken)
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"lambda/types"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestFoldICSLine(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{name: "short", line: "SUMMARY:Expires: Passport"},
+		{name: "exactly the limit", line: "SUMMARY:" + strings.Repeat("a", icsLineLimit-len("SUMMARY:"))},
+		{name: "long ASCII", line: "DESCRIPTION:" + strings.Repeat("abcdefghij", 20)},
+		{name: "two byte characters", line: "SUMMARY:" + strings.Repeat("é", 100)},
+		{name: "three byte characters", line: "SUMMARY:" + strings.Repeat("日本", 60)},
+		{name: "four byte characters", line: "SUMMARY:x" + strings.Repeat("📄", 50)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			folded := foldICSLine(tt.line)
+			if !strings.HasSuffix(folded, "\r\n") {
+				t.Fatalf("folded line %q does not end in CRLF", folded)
+			}
+			lines := strings.Split(strings.TrimSuffix(folded, "\r\n"), "\r\n")
+			for i, line := range lines {
+				if len(line) > icsLineLimit {
+					t.Errorf("line %d is %d octets: %q", i, len(line), line)
+				}
+				if i > 0 && !strings.HasPrefix(line, " ") {
+					t.Errorf("continuation line %d does not start with a space: %q", i, line)
+				}
+				if !utf8.ValidString(line) {
+					t.Errorf("line %d splits a character: %q", i, line)
+				}
+			}
+			if len(tt.line) <= icsLineLimit && len(lines) != 1 {
+				t.Errorf("line of %d octets folded into %d lines", len(tt.line), len(lines))
+			}
+			if unfolded := strings.ReplaceAll(strings.TrimSuffix(folded, "\r\n"), "\r\n ", ""); unfolded != tt.line {
+				t.Errorf("unfolded line = %q, want %q", unfolded, tt.line)
+			}
+		})
+	}
+}
+
+func TestEscapeICSText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Passport", "Passport"},
+		{"Lease, office; floor 2", `Lease\, office\; floor 2`},
+		{`C:\docs`, `C:\\docs`},
+		{"line one\nline two\r\nline three", `line one\nline two\nline three`},
+		{`\n`, `\\n`},
+	}
+	for _, tt := range tests {
+		if got := escapeICSText(tt.text); got != tt.want {
+			t.Errorf("escapeICSText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestICSDuration(t *testing.T) {
+	tests := []struct {
+		minutes int64
+		want    string
+	}{
+		{0, "-PT0M"},
+		{24 * 60, "-P1D"},
+		{28 * 24 * 60, "-P28D"},
+		{90, "-PT90M"},
+	}
+	for _, tt := range tests {
+		if got := icsDuration(tt.minutes); got != tt.want {
+			t.Errorf("icsDuration(%d) = %q, want %q", tt.minutes, got, tt.want)
+		}
+	}
+}
+
+func TestRenderCalendarFeed(t *testing.T) {
+	passport := &types.Document{ID: "passport", DocumentName: "Passport, Ann; renewal", ExpiryDate: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), Status: types.StatusExpiringSoon, Sheet: "Sheet1", Row: 2}
+	visa := &types.Document{ID: "visa", DocumentName: "Visa", ExpiryDate: time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC), Status: types.StatusValid, Sheet: "Sheet1", Row: 3}
+	results := func() []*cachedResult {
+		return []*cachedResult{{
+			Spreadsheet: &types.Spreadsheet{
+				SheetSettings: types.SheetSettings{SpreadsheetID: "sheet-1"},
+				Calendar:      types.CalendarSettings{ReminderDays: []int{7}},
+			},
+			Result: &types.CheckResult{SheetResult: types.SheetResult{Documents: []*types.Document{passport, visa}}},
+		}}
+	}
+
+	feed := renderCalendarFeed(results(), time.Date(2025, 3, 20, 8, 0, 0, 0, time.UTC))
+	if !strings.HasPrefix(feed, "BEGIN:VCALENDAR\r\n") || !strings.HasSuffix(feed, "END:VCALENDAR\r\n") {
+		t.Errorf("feed is not a VCALENDAR:\n%s", feed)
+	}
+	if strings.Count(feed, "\n") != strings.Count(feed, "\r\n") {
+		t.Error("feed has lines not ending in CRLF")
+	}
+	for _, want := range []string{
+		"SUMMARY:Expires: Passport\\, Ann\\; renewal\r\n",
+		"DTSTART;VALUE=DATE:20250401\r\n",
+		"DTEND;VALUE=DATE:20250402\r\n",
+		"TRIGGER:-P7D\r\n",
+		"DTSTAMP:20250320T080000Z\r\n",
+	} {
+		if !strings.Contains(feed, want) {
+			t.Errorf("feed does not contain %q", want)
+		}
+	}
+	if strings.Count(feed, "BEGIN:VEVENT") != 2 || strings.Count(feed, "BEGIN:VALARM") != 2 {
+		t.Errorf("feed has %d events and %d alarms, want 2 of each", strings.Count(feed, "BEGIN:VEVENT"), strings.Count(feed, "BEGIN:VALARM"))
+	}
+
+	uidPattern := regexp.MustCompile(`UID:([^\r]+)\r\n`)
+	uids := func(feed string) []string {
+		var found []string
+		for _, match := range uidPattern.FindAllStringSubmatch(feed, -1) {
+			found = append(found, match[1])
+		}
+		return found
+	}
+	first := uids(feed)
+	if len(first) != 2 || first[0] == first[1] {
+		t.Fatalf("UIDs = %v, want one per document", first)
+	}
+
+	// The UID survives later renders, row moves and status changes
+	passport.Row, passport.Status = 12, types.StatusExpired
+	again := uids(renderCalendarFeed(results(), time.Date(2025, 4, 2, 8, 0, 0, 0, time.UTC)))
+	if strings.Join(again, ",") != strings.Join(first, ",") {
+		t.Errorf("UIDs changed from %v to %v", first, again)
+	}
+	if want := calendarEventID("sheet-1", "passport") + "@docexpiry"; first[0] != want {
+		t.Errorf("UID = %q, want %q", first[0], want)
+	}
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"lambda/types"
+	"net/http"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// Private extended properties tagging the events we own.
+const (
+	calendarSpreadsheetProperty = "docexpiry_spreadsheet"
+	calendarDocumentProperty    = "docexpiry_document"
+)
+
+// Popup reminders used when a spreadsheet does not configure its own.
+var defaultCalendarReminderDays = []int{28, 7, 1}
+
+// Google Calendar limits reminders to five per event and four weeks ahead.
+const (
+	maxCalendarReminders       = 5
+	maxCalendarReminderMinutes = 40320
+)
+
+// CalendarSync keeps one all-day event per document on its expiry date.
+type CalendarSync struct {
+	Service  *calendar.Service
+	Settings *types.CalendarSettings
+}
+
+func NewCalendarSync(service *calendar.Service, settings *types.CalendarSettings) *CalendarSync {
+	return &CalendarSync{
+		Service:  service,
+		Settings: settings,
+	}
+}
+
+// Sync creates or updates the event of every document and deletes the
+// events of documents that are no longer in the spreadsheet. The tagged
+// events are listed once and only those that differ are written, so a run
+// over an unchanged sheet makes a single request.
+func (cs *CalendarSync) Sync(spreadsheetID string, docs []*types.Document) error {
+	calendarID := cs.calendarID()
+
+	// Deleted events are listed too: their IDs stay taken, so a returning
+	// document has to restore its event rather than insert it
+	existing := map[string]*calendar.Event{}
+	err := cs.Service.Events.List(calendarID).
+		PrivateExtendedProperty(calendarSpreadsheetProperty+"="+spreadsheetID).
+		ShowDeleted(true).
+		Fields("nextPageToken", "items(id,status,summary,description,start,end,transparency,reminders)").
+		Pages(context.Background(), func(page *calendar.Events) error {
+			for _, event := range page.Items {
+				existing[event.Id] = event
+			}
+			return nil
+		})
+	if err != nil {
+		return fmt.Errorf("error listing calendar events: %w", err)
+	}
+
+	current := map[string]bool{}
+	for _, doc := range docs {
+		event := cs.buildEvent(spreadsheetID, doc)
+		current[event.Id] = true
+
+		old, found := existing[event.Id]
+		switch {
+		case !found:
+			err = cs.insertEvent(calendarID, event)
+		case eventChanged(old, event):
+			_, err = cs.Service.Events.Patch(calendarID, event.Id, event).Do()
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error syncing calendar event for %q: %w", doc.DocumentName, err)
+		}
+	}
+
+	// Remove events whose rows were deleted from the sheet
+	for id, event := range existing {
+		if current[id] || event.Status == "cancelled" {
+			continue
+		}
+		err := cs.Service.Events.Delete(calendarID, id).Do()
+		if err != nil && !isGoogleAPIStatus(err, http.StatusGone) && !isGoogleAPIStatus(err, http.StatusNotFound) {
+			return fmt.Errorf("error deleting calendar event %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// insertEvent adds a new event. An event holding the ID without our tags,
+// e.g. one whose tags were edited away, is overwritten instead.
+func (cs *CalendarSync) insertEvent(calendarID string, event *calendar.Event) error {
+	_, err := cs.Service.Events.Insert(calendarID, event).Do()
+	if isGoogleAPIStatus(err, http.StatusConflict) {
+		_, err = cs.Service.Events.Update(calendarID, event.Id, event).Do()
+	}
+	return err
+}
+
+// eventChanged reports whether a listed event differs from the one built
+// for its document in any of the fields we set.
+func eventChanged(old, event *calendar.Event) bool {
+	if old.Status != event.Status || old.Summary != event.Summary || old.Description != event.Description ||
+		old.Transparency != event.Transparency {
+		return true
+	}
+	if old.Start == nil || old.End == nil || old.Start.Date != event.Start.Date || old.End.Date != event.End.Date {
+		return true
+	}
+	if old.Reminders == nil || old.Reminders.UseDefault {
+		return true
+	}
+	return reminderKey(old.Reminders.Overrides) != reminderKey(event.Reminders.Overrides)
+}
+
+// reminderKey describes a set of reminders independent of their order.
+func reminderKey(reminders []*calendar.EventReminder) string {
+	var keys []string
+	for _, reminder := range reminders {
+		keys = append(keys, fmt.Sprintf("%s:%d", reminder.Method, reminder.Minutes))
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// buildEvent renders a document as an all-day event on its expiry date.
+func (cs *CalendarSync) buildEvent(spreadsheetID string, doc *types.Document) *calendar.Event {
+	expiry := doc.ExpiryDate.Format("2006-01-02")
+	nextDay := doc.ExpiryDate.AddDate(0, 0, 1).Format("2006-01-02")
+
+	var overrides []*calendar.EventReminder
+	for _, minutes := range cs.reminderMinutes() {
+		overrides = append(overrides, &calendar.EventReminder{Method: "popup", Minutes: minutes, ForceSendFields: []string{"Minutes"}})
+	}
+
+	return &calendar.Event{
+		Id:           calendarEventID(spreadsheetID, doc.ID),
+		Summary:      eventSummary(doc),
+		Description:  eventDescription(spreadsheetID, doc),
+		Status:       "confirmed",
+		Start:        &calendar.EventDateTime{Date: expiry},
+		End:          &calendar.EventDateTime{Date: nextDay},
+		Transparency: "transparent",
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{
+				calendarSpreadsheetProperty: spreadsheetID,
+				calendarDocumentProperty:    doc.ID,
+			},
+		},
+		Reminders: &calendar.EventReminders{
+			UseDefault:      false,
+			Overrides:       overrides,
+			ForceSendFields: []string{"UseDefault"},
+		},
+	}
+}
+
+// eventSummary is the title of a document's calendar event.
+func eventSummary(doc *types.Document) string {
+	return fmt.Sprintf("Expires: %s", doc.DocumentName)
+}
+
+// eventDescription tells where a document's expiry date was read from.
+func eventDescription(spreadsheetID string, doc *types.Document) string {
+	description := fmt.Sprintf("%s expires on %s.\n\nSheet: %s, row %d\n%s",
+		doc.DocumentName, doc.ExpiryDate.Format("2006-01-02"), doc.Sheet, doc.Row, spreadsheetURL(spreadsheetID))
+	if !doc.IssueDate.IsZero() {
+		description = fmt.Sprintf("Issued on %s.\n%s", doc.IssueDate.Format("2006-01-02"), description)
+	}
+	return description
+}
+
+func spreadsheetURL(spreadsheetID string) string {
+	return "https://docs.google.com/spreadsheets/d/" + spreadsheetID
+}
+
+func (cs *CalendarSync) calendarID() string {
+	if cs.Settings.CalendarID != "" {
+		return cs.Settings.CalendarID
+	}
+	return "primary"
+}
+
+// reminderMinutes converts the configured reminder days into minutes before
+// the start of the expiry day, within the limits Google Calendar accepts.
+func (cs *CalendarSync) reminderMinutes() []int64 {
+	days := cs.Settings.ReminderDays
+	if len(days) == 0 {
+		days = defaultCalendarReminderDays
+	}
+
+	seen := map[int64]bool{}
+	var minutes []int64
+	for _, day := range days {
+		if day < 0 {
+			continue
+		}
+		m := int64(day) * 24 * 60
+		if m > maxCalendarReminderMinutes {
+			m = maxCalendarReminderMinutes
+		}
+		if !seen[m] {
+			seen[m] = true
+			minutes = append(minutes, m)
+		}
+	}
+	sort.Slice(minutes, func(i, j int) bool { return minutes[i] > minutes[j] })
+	if len(minutes) > maxCalendarReminders {
+		minutes = minutes[:maxCalendarReminders]
+	}
+	return minutes
+}
+
+// calendarEventID derives the event ID from the document identity. Event IDs
+// may only use base32hex characters, which lowercase hex satisfies.
+func calendarEventID(spreadsheetID, documentID string) string {
+	sum := sha256.Sum256([]byte(spreadsheetID + "\x00" + documentID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// isGoogleAPIStatus reports whether err is a Google API error with the given
+// HTTP status code.
+func isGoogleAPIStatus(err error, code int) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"lambda/types"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// testCalendar is a Calendar API stand-in listing the given events and
+// recording every other request.
+type testCalendar struct {
+	mu       sync.Mutex
+	lists    int
+	requests []string // "METHOD event-id"
+}
+
+func newTestCalendarSync(t *testing.T, settings *types.CalendarSettings, events []*calendar.Event) (*CalendarSync, *testCalendar) {
+	t.Helper()
+	recorder := &testCalendar{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recorder.mu.Lock()
+		defer recorder.mu.Unlock()
+
+		id := strings.TrimPrefix(r.URL.Path, "/calendars/primary/events")
+		id = strings.TrimPrefix(id, "/")
+		switch {
+		case r.Method == http.MethodGet && id == "":
+			recorder.lists++
+			json.NewEncoder(w).Encode(&calendar.Events{Items: events})
+			return
+		case r.Method == http.MethodPost:
+			var event calendar.Event
+			json.NewDecoder(r.Body).Decode(&event)
+			id = event.Id
+		}
+		recorder.requests = append(recorder.requests, r.Method+" "+id)
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+
+	service, err := calendar.NewService(context.Background(),
+		option.WithHTTPClient(server.Client()),
+		option.WithEndpoint(server.URL+"/"))
+	if err != nil {
+		t.Fatalf("calendar.NewService: %v", err)
+	}
+	return NewCalendarSync(service, settings), recorder
+}
+
+func TestCalendarSyncOnlyWritesChanges(t *testing.T) {
+	settings := &types.CalendarSettings{Enabled: true}
+	builder := &CalendarSync{Settings: settings}
+	doc := func(id string, expiry time.Time) *types.Document {
+		return &types.Document{ID: id, DocumentName: id, ExpiryDate: expiry, Sheet: "Sheet1", Row: 2}
+	}
+	unchanged := doc("passport", time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC))
+	renewed := doc("visa", time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC))
+	added := doc("licence", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC))
+	removed := doc("lease", time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC))
+	returning := doc("insurance", time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC))
+	gone := doc("permit", time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC))
+
+	// Google returns reminders in its own order
+	listedUnchanged := builder.buildEvent("sheet-1", unchanged)
+	overrides := listedUnchanged.Reminders.Overrides
+	for i, j := 0, len(overrides)-1; i < j; i, j = i+1, j-1 {
+		overrides[i], overrides[j] = overrides[j], overrides[i]
+	}
+	listedRenewed := builder.buildEvent("sheet-1", doc("visa", time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)))
+	listedReturning := builder.buildEvent("sheet-1", returning)
+	listedReturning.Status = "cancelled"
+	listedGone := builder.buildEvent("sheet-1", gone)
+	listedGone.Status = "cancelled"
+
+	calendarSync, recorder := newTestCalendarSync(t, settings, []*calendar.Event{
+		listedUnchanged,
+		listedRenewed,
+		builder.buildEvent("sheet-1", removed),
+		listedReturning,
+		listedGone,
+	})
+	if err := calendarSync.Sync("sheet-1", []*types.Document{unchanged, renewed, added, returning}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	want := []string{
+		"PATCH " + calendarEventID("sheet-1", "visa"),
+		"POST " + calendarEventID("sheet-1", "licence"),
+		"PATCH " + calendarEventID("sheet-1", "insurance"),
+		"DELETE " + calendarEventID("sheet-1", "lease"),
+	}
+	sort.Strings(want)
+	sort.Strings(recorder.requests)
+	if strings.Join(recorder.requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests =\n%s\nwant\n%s", strings.Join(recorder.requests, "\n"), strings.Join(want, "\n"))
+	}
+	if recorder.lists != 1 {
+		t.Errorf("events listed %d times, want once", recorder.lists)
+	}
+}
+
+func TestCalendarSyncUnchangedSheet(t *testing.T) {
+	settings := &types.CalendarSettings{Enabled: true, ReminderDays: []int{30, 1}}
+	builder := &CalendarSync{Settings: settings}
+	var docs []*types.Document
+	var events []*calendar.Event
+	for i := 0; i < 300; i++ {
+		doc := &types.Document{ID: string(rune('a'+i%26)) + strings.Repeat("x", i/26), DocumentName: "Document", ExpiryDate: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, i)}
+		docs = append(docs, doc)
+		events = append(events, builder.buildEvent("sheet-1", doc))
+	}
+
+	calendarSync, recorder := newTestCalendarSync(t, settings, events)
+	if err := calendarSync.Sync("sheet-1", docs); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if recorder.lists != 1 || len(recorder.requests) != 0 {
+		t.Errorf("%d lists and requests %v, want a single list", recorder.lists, recorder.requests)
+	}
+}
+
+func TestEventChanged(t *testing.T) {
+	builder := &CalendarSync{Settings: &types.CalendarSettings{}}
+	doc := &types.Document{ID: "passport", DocumentName: "Passport", ExpiryDate: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)}
+
+	tests := []struct {
+		name   string
+		change func(*calendar.Event)
+		want   bool
+	}{
+		{name: "same event", change: func(*calendar.Event) {}},
+		{name: "renamed", change: func(e *calendar.Event) { e.Summary = "Expires: Old passport" }, want: true},
+		{name: "moved", change: func(e *calendar.Event) { e.Start.Date = "2025-03-01" }, want: true},
+		{name: "deleted", change: func(e *calendar.Event) { e.Status = "cancelled" }, want: true},
+		{name: "default reminders", change: func(e *calendar.Event) { e.Reminders.UseDefault = true }, want: true},
+		{name: "reminder removed", change: func(e *calendar.Event) { e.Reminders.Overrides = e.Reminders.Overrides[1:] }, want: true},
+		{name: "no reminders listed", change: func(e *calendar.Event) { e.Reminders = nil }, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := builder.buildEvent("sheet-1", doc)
+			tt.change(old)
+			if got := eventChanged(old, builder.buildEvent("sheet-1", doc)); got != tt.want {
+				t.Errorf("eventChanged = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
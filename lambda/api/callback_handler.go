@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"io/ioutil"
@@ -19,18 +20,23 @@ import (
 )
 
 type compositeState struct {
-	Nonce         string `json:"nonce"`
-	SpreadsheetID string `json:"spreadsheet_id"`
+	Nonce string `json:"nonce"`
+	types.SheetSettings
+	Notifications types.NotificationSettings `json:"notifications"`
+	Calendar      types.CalendarSettings     `json:"calendar"`
 }
 
 type CallBackHandler struct {
 	Auth          *auth.AuthConfig
 	databaseStore *database.DynamoDBStore
+	sessions      *auth.SessionSigner
 }
 
-func NewCallbackHandler(dbStore *database.DynamoDBStore) *CallBackHandler {
+func NewCallbackHandler(dbStore *database.DynamoDBStore, authConfig *auth.AuthConfig, sessions *auth.SessionSigner) *CallBackHandler {
 	return &CallBackHandler{
+		Auth:          authConfig,
 		databaseStore: dbStore,
+		sessions:      sessions,
 	}
 }
 
@@ -45,14 +51,24 @@ func (cb *CallBackHandler) OauthCallback(request events.APIGatewayProxyRequest)
 
 	// Handle state parameter and decode it
 	stateParam := strings.TrimSpace(request.QueryStringParameters["state"])
-	fmt.Println("CallbackHandler invoked with state:", stateParam)
 
 	// Get composite state from query parameter
 	composite, err := decodeState(stateParam)
 	if err != nil {
-		return errorResponse(http.StatusInternalServerError, err.Error(), corsHeaders), nil
+		return errorResponse(http.StatusBadRequest, err.Error(), corsHeaders), nil
 	}
-	fmt.Printf("composite state %v", composite)
+
+	// Reject states this browser did not start, replayed and expired states
+	signIn, err := verifyOAuthState(cb.databaseStore, request, stateParam, composite, time.Now())
+	if err != nil {
+		if errors.Is(err, errInvalidState) {
+			return errorResponse(http.StatusBadRequest, err.Error(), corsHeaders), nil
+		}
+		fmt.Printf("failed to verify sign in state: %v\n", err)
+		return errorResponse(http.StatusInternalServerError, "error verifying sign in", corsHeaders), nil
+	}
+	// The sign in is consumed, so every response from here on drops its cookie
+	corsHeaders["Set-Cookie"] = clearOAuthStateCookie()
 
 	// Get authorization code
 	code := request.QueryStringParameters["code"]
@@ -60,10 +76,8 @@ func (cb *CallBackHandler) OauthCallback(request events.APIGatewayProxyRequest)
 		return errorResponse(http.StatusBadRequest, "missing code", corsHeaders), nil
 	}
 
-	fmt.Printf("code %v", code)
-
 	// Exchange code for token
-	token, err := getOAuthToken(code)
+	token, err := getOAuthToken(cb.Auth, code, signIn.CodeVerifier)
 	if err != nil {
 		return errorResponse(http.StatusInternalServerError, "authentication failed", corsHeaders), nil
 	}
@@ -95,6 +109,7 @@ func (cb *CallBackHandler) OauthCallback(request events.APIGatewayProxyRequest)
 		AccessToken:  token.AccessToken,
 		TokenType:    token.TokenType,
 		RefreshToken: token.RefreshToken,
+		Scope:        auth.GrantedScope(token),
 		Expiry:       token.Expiry,
 		ExpiresIn:    int64(token.Expiry.Sub(time.Now()).Seconds()),
 		CreatedAt:    time.Now(),
@@ -104,38 +119,108 @@ func (cb *CallBackHandler) OauthCallback(request events.APIGatewayProxyRequest)
 		Raw:          token,
 	}
 
-	// Store token in database
-	err = cb.databaseStore.StoreToken(customToken)
+	// Store token in database. Without it the dashboard and scheduled checks
+	// cannot reach the sheet, so the user is not signed in.
+	if err := storeUserToken(cb.databaseStore, customToken); err != nil {
+		fmt.Printf("failed to store token: %v\n", err)
+		return errorResponse(http.StatusInternalServerError, "error storing token", corsHeaders), nil
+	}
+
+	// Register the spreadsheet so scheduled checks pick it up
+	spreadsheet := &types.Spreadsheet{
+		UserID:        userInfo.ID,
+		SheetSettings: composite.SheetSettings,
+		Notifications: composite.Notifications,
+		Calendar:      composite.Calendar,
+	}
+	if err := cb.registerSpreadsheet(spreadsheet); err != nil {
+		fmt.Printf("failed to register spreadsheet: %v\n", err)
+	}
 
-	// Process spreadsheet data
-	sheetProcessor := NewSheetProcessor(googleServices.SheetsService)
-	docs, err := sheetProcessor.ProcessSheetData(composite.SpreadsheetID, "Sheet1!A1:E10")
+	// Check the spreadsheet and email the summary
+	result, err := NewExpiryCheck(googleServices, userInfo, spreadsheet, cb.databaseStore).Run(time.Now())
+	var missingColumns *MissingColumnsError
+	if errors.As(err, &missingColumns) {
+		return errorResponse(http.StatusUnprocessableEntity, missingColumns.Error(), corsHeaders), nil
+	}
 	if err != nil {
-		return errorResponse(http.StatusInternalServerError, "error processing spreadsheet data", corsHeaders), nil
+		fmt.Printf("expiry check failed: %v\n", err)
+		return errorResponse(http.StatusInternalServerError, "error checking spreadsheet", corsHeaders), nil
 	}
 
-	// Send email with document summary
-	emailSender := NewEmailSender(googleServices.GmailService, userInfo)
-	err = emailSender.SendDocumentSummary(docs)
+	// Sign the user in to the dashboard
+	sessionToken, session, err := cb.sessions.Issue(userInfo.ID, userInfo.Email, time.Now())
 	if err != nil {
-		return errorResponse(http.StatusInternalServerError, "error sending email", corsHeaders), nil
+		fmt.Printf("failed to issue session: %v\n", err)
+		return errorResponse(http.StatusInternalServerError, "error signing in", corsHeaders), nil
 	}
 
-	// Redirect to summary page
+	// Redirect to summary page, flagging rows that were skipped. Browsers do
+	// not expose the body of a redirect, so the dashboard lists the skipped
+	// rows from GET /summary.
+	body, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(http.StatusInternalServerError, "error encoding result", corsHeaders), nil
+	}
+	// The calendar feed URL is a credential, so the dashboard reads it from
+	// GET /summary rather than the redirect, which ends up in browser history
+	location := fmt.Sprintf("http://localhost:3000/summary?issues=%d", len(result.Issues))
 	return events.APIGatewayProxyResponse{
 		StatusCode: http.StatusFound, // 302
 		Headers: map[string]string{
-			"Location":                         "http://localhost:3000/summary",
+			"Location":                         location,
 			"Content-Type":                     "application/json",
 			"Access-Control-Allow-Origin":      "http://localhost:3000",
 			"Access-Control-Allow-Credentials": "true",
 			"Access-Control-Allow-Methods":     "GET,POST,PUT,DELETE,OPTIONS",
 			"Access-Control-Allow-Headers":     "Content-Type,X-Amz-Date,Authorization,X-Api-Key,X-Amz-Security-Token",
 		},
-		Body: "",
+		MultiValueHeaders: map[string][]string{
+			"Set-Cookie": {auth.SessionCookie(sessionToken, session), clearOAuthStateCookie()},
+		},
+		Body: string(body),
 	}, nil
 }
 
+// Helper function to register a spreadsheet, replacing the settings of one
+// the user registered before
+func (cb *CallBackHandler) registerSpreadsheet(spreadsheet *types.Spreadsheet) error {
+	err := cb.databaseStore.CreateSpreadsheet(spreadsheet)
+	if !errors.Is(err, database.ErrSpreadsheetExists) {
+		return err
+	}
+
+	// Replace the settings but keep the registration time and summary thread
+	existing, err := cb.databaseStore.GetSpreadsheet(spreadsheet.UserID, spreadsheet.SpreadsheetID)
+	if err != nil {
+		return err
+	}
+	// Channels are configured through the settings endpoint, not at sign in
+	channels := existing.Notifications.Channels
+	existing.SheetSettings = spreadsheet.SheetSettings
+	existing.Notifications = spreadsheet.Notifications
+	existing.Notifications.Channels = channels
+	existing.Calendar = spreadsheet.Calendar
+	*spreadsheet = *existing
+	return cb.databaseStore.UpdateSpreadsheet(spreadsheet)
+}
+
+// Helper function to store the token of a sign in. Google only returns a
+// refresh token the first time a user consents, so later sign ins carry the
+// refresh token of the user's latest token forward.
+func storeUserToken(dbStore *database.DynamoDBStore, token *types.Token) error {
+	if token.RefreshToken == "" {
+		previous, err := dbStore.LatestToken(token.UserID)
+		switch {
+		case err == nil:
+			token.RefreshToken = previous.RefreshToken
+		case !errors.Is(err, database.ErrTokenNotFound):
+			return err
+		}
+	}
+	return dbStore.StoreToken(token)
+}
+
 // Helper function to decode state parameter
 func decodeState(stateParam string) (*compositeState, error) {
 	raw, err := base64.URLEncoding.DecodeString(stateParam)
@@ -152,10 +237,11 @@ func decodeState(stateParam string) (*compositeState, error) {
 	return &composite, nil
 }
 
-// Helper function to get OAuth token
-func getOAuthToken(code string) (*oauth2.Token, error) {
-	oauthCfg := auth.NewAuthConfig().ToOAuth2Config()
-	token, err := oauthCfg.Exchange(context.Background(), code)
+// Helper function to get OAuth token, presenting the PKCE verifier of the
+// sign in
+func getOAuthToken(authConfig *auth.AuthConfig, code, codeVerifier string) (*oauth2.Token, error) {
+	oauthCfg := authConfig.ToOAuth2Config()
+	token, err := oauthCfg.Exchange(context.Background(), code, oauth2.VerifierOption(codeVerifier))
 	if err != nil {
 		return nil, err
 	}
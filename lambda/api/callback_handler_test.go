@@ -0,0 +1,50 @@
+package api
+
+import (
+	"lambda/database"
+	"lambda/types"
+	"testing"
+)
+
+func TestStoreUserTokenKeepsRefreshToken(t *testing.T) {
+	dbStore, fake := newTestStore(t)
+	signIn := func(accessToken, refreshToken string) *types.Token {
+		t.Helper()
+		token := &types.Token{UserID: "user-1", Email: "ann@example.com", AccessToken: accessToken, RefreshToken: refreshToken}
+		if err := storeUserToken(dbStore, token); err != nil {
+			t.Fatalf("storeUserToken: %v", err)
+		}
+		return token
+	}
+
+	// A first sign in without offline access has nothing to carry forward
+	if token := signIn("access-0", ""); token.RefreshToken != "" {
+		t.Errorf("refresh token = %q, want none", token.RefreshToken)
+	}
+
+	signIn("access-1", "refresh-1")
+	if _, err := dbStore.RevokeTokens("user-1"); err != nil {
+		t.Fatalf("RevokeTokens: %v", err)
+	}
+	signIn("access-2", "refresh-2")
+
+	// Google returns no refresh token once the user has consented
+	if token := signIn("access-3", ""); token.RefreshToken != "refresh-2" {
+		t.Errorf("second sign in stored refresh token %q, want %q", token.RefreshToken, "refresh-2")
+	}
+	latest, err := dbStore.LatestToken("user-1")
+	if err != nil {
+		t.Fatalf("LatestToken: %v", err)
+	}
+	if latest.RefreshToken != "refresh-2" {
+		t.Errorf("latest refresh token = %q, want %q", latest.RefreshToken, "refresh-2")
+	}
+
+	// A new refresh token replaces the old one
+	if token := signIn("access-4", "refresh-4"); token.RefreshToken != "refresh-4" {
+		t.Errorf("refresh token = %q, want %q", token.RefreshToken, "refresh-4")
+	}
+	if items := fake.Items(database.TABLE_NAME); len(items) != 5 {
+		t.Errorf("%d tokens stored, want 5", len(items))
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"fmt"
+	"lambda/types"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	// Lambda's provided runtimes ship without a zoneinfo database
+	_ "time/tzdata"
+)
+
+// Layouts tried for every sheet regardless of locale.
+var defaultDateLayouts = []string{
+	"2006-01-02",
+	"2006/01/02",
+	"2 Jan 2006",
+	"2 January 2006",
+	"2-Jan-2006",
+	"2-Jan-06",
+	"Jan 2, 2006",
+	"January 2, 2006",
+	"Jan 2 2006",
+	"2006-01-02T15:04:05Z07:00",
+}
+
+// Numeric layouts whose meaning depends on the locale. Single digit layout
+// elements also accept zero padded values.
+var (
+	dayFirstLayouts   = []string{"2/1/2006", "2-1-2006", "2.1.2006", "2/1/06"}
+	monthFirstLayouts = []string{"1/2/2006", "1-2-2006", "1.2.2006", "1/2/06"}
+)
+
+// Google Sheets serial dates count days from 1899-12-30.
+var sheetsEpoch = time.Date(1899, time.December, 30, 0, 0, 0, 0, time.UTC)
+
+// Serial numbers outside this range are treated as plain numbers rather
+// than dates (1900-01-01 to 9999-12-31).
+const (
+	minSerialDate = 2
+	maxSerialDate = 2958465
+)
+
+// DateParser converts sheet cells into dates using the layouts, day order and
+// timezone configured for a spreadsheet.
+type DateParser struct {
+	Layouts  []string
+	Location *time.Location
+}
+
+func NewDateParser(settings *types.SheetSettings) (*DateParser, error) {
+	location := time.UTC
+	if settings.Timezone != "" {
+		loc, err := time.LoadLocation(settings.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", settings.Timezone, err)
+		}
+		location = loc
+	}
+
+	// Custom layouts win over the defaults, then the locale decides how
+	// ambiguous numeric dates such as 03/04/2025 are read.
+	layouts := append([]string{}, settings.DateLayouts...)
+	layouts = append(layouts, defaultDateLayouts...)
+	if settings.DayFirst {
+		layouts = append(layouts, dayFirstLayouts...)
+	} else {
+		layouts = append(layouts, monthFirstLayouts...)
+	}
+
+	return &DateParser{
+		Layouts:  layouts,
+		Location: location,
+	}, nil
+}
+
+// Parse reads a cell value as a date. Values fetched with UNFORMATTED_VALUE
+// arrive as serial numbers; text cells are matched against the layouts.
+func (dp *DateParser) Parse(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case float64:
+		return dp.fromSerial(v)
+	case int:
+		return dp.fromSerial(float64(v))
+	case nil:
+		return time.Time{}, fmt.Errorf("empty date")
+	}
+
+	raw := strings.TrimSpace(fmt.Sprintf("%v", value))
+	if raw == "" {
+		return time.Time{}, fmt.Errorf("empty date")
+	}
+	if serial, err := strconv.ParseFloat(raw, 64); err == nil {
+		return dp.fromSerial(serial)
+	}
+
+	for _, layout := range dp.Layouts {
+		if t, err := time.ParseInLocation(layout, raw, dp.Location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date format %q", raw)
+}
+
+// fromSerial converts a spreadsheet serial number into a date in the
+// parser's timezone. The fractional part is the time of day.
+func (dp *DateParser) fromSerial(serial float64) (time.Time, error) {
+	if serial < minSerialDate || serial > maxSerialDate {
+		return time.Time{}, fmt.Errorf("serial date %v out of range", serial)
+	}
+	days, fraction := math.Modf(serial)
+	date := sheetsEpoch.AddDate(0, 0, int(days))
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, dp.Location)
+	return midnight.Add(time.Duration(math.Round(fraction*86400)) * time.Second), nil
+}
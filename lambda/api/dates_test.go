@@ -0,0 +1,86 @@
+package api
+
+import (
+	"lambda/types"
+	"testing"
+	"time"
+)
+
+func TestDateParserParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings types.SheetSettings
+		value    interface{}
+		want     time.Time
+		wantErr  bool
+	}{
+		{name: "ISO date", value: "2025-03-04", want: time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{name: "written month", value: " 4 March 2025 ", want: time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{name: "US written month", value: "Mar 4, 2025", want: time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{name: "numeric month first by default", value: "03/04/2025", want: time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{
+			name:     "numeric day first",
+			settings: types.SheetSettings{DayFirst: true},
+			value:    "03/04/2025",
+			want:     time.Date(2025, 4, 3, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "custom layout",
+			settings: types.SheetSettings{DateLayouts: []string{"2006.01.02"}},
+			value:    "2025.03.04",
+			want:     time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC),
+		},
+		{name: "serial number", value: float64(45720), want: time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{name: "serial with time of day", value: 45720.5, want: time.Date(2025, 3, 4, 12, 0, 0, 0, time.UTC)},
+		{name: "serial as text", value: "45720", want: time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{name: "serial as int", value: 45720, want: time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC)},
+		{name: "serial out of range", value: float64(1), wantErr: true},
+		{name: "empty text", value: "  ", wantErr: true},
+		{name: "nil", value: nil, wantErr: true},
+		{name: "not a date", value: "next tuesday", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := tt.settings
+			parser, err := NewDateParser(&settings)
+			if err != nil {
+				t.Fatalf("NewDateParser: %v", err)
+			}
+			got, err := parser.Parse(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%v) = %v, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%v): %v", tt.value, err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("Parse(%v) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDateParserTimezone(t *testing.T) {
+	parser, err := NewDateParser(&types.SheetSettings{Timezone: "Australia/Sydney"})
+	if err != nil {
+		t.Fatalf("NewDateParser: %v", err)
+	}
+	sydney, _ := time.LoadLocation("Australia/Sydney")
+
+	for _, value := range []interface{}{"2025-03-04", float64(45720)} {
+		got, err := parser.Parse(value)
+		if err != nil {
+			t.Fatalf("Parse(%v): %v", value, err)
+		}
+		if want := time.Date(2025, 3, 4, 0, 0, 0, 0, sydney); !got.Equal(want) {
+			t.Errorf("Parse(%v) = %v, want %v", value, got, want)
+		}
+	}
+
+	if _, err := NewDateParser(&types.SheetSettings{Timezone: "Mars/Olympus"}); err == nil {
+		t.Error("NewDateParser accepted an unknown timezone")
+	}
+}
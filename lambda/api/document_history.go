@@ -0,0 +1,108 @@
+package api
+
+import (
+	"lambda/database"
+	"lambda/types"
+	"time"
+)
+
+// DocumentRecorder stores the documents of each check and records how they
+// changed since the previous one, so it can be shown when a document was
+// renewed, changed status or disappeared from the sheet.
+type DocumentRecorder struct {
+	databaseStore *database.DynamoDBStore
+}
+
+func NewDocumentRecorder(dbStore *database.DynamoDBStore) *DocumentRecorder {
+	return &DocumentRecorder{
+		databaseStore: dbStore,
+	}
+}
+
+// Record compares the documents read from a spreadsheet with the stored
+// ones, stores the new state and returns the changes recorded.
+func (dr *DocumentRecorder) Record(spreadsheet *types.Spreadsheet, docs []*types.Document, now time.Time) ([]*types.DocumentChange, error) {
+	stored, err := dr.databaseStore.ListDocuments(spreadsheet.UserID, spreadsheet.SpreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+	previous := map[string]*types.StoredDocument{}
+	for _, doc := range stored {
+		previous[doc.ID] = doc
+	}
+
+	var changes []*types.DocumentChange
+	addChange := func(doc *types.Document, change, oldValue, newValue string) {
+		changes = append(changes, &types.DocumentChange{
+			UserID:        spreadsheet.UserID,
+			SpreadsheetID: spreadsheet.SpreadsheetID,
+			DocumentID:    doc.ID,
+			DocumentName:  doc.DocumentName,
+			Change:        change,
+			OldValue:      oldValue,
+			NewValue:      newValue,
+			ChangedAt:     now,
+		})
+	}
+
+	var updated []*types.StoredDocument
+	seen := map[string]bool{}
+	for _, doc := range docs {
+		seen[doc.ID] = true
+		current := &types.StoredDocument{
+			UserID:        spreadsheet.UserID,
+			SpreadsheetID: spreadsheet.SpreadsheetID,
+			Document:      *doc,
+			FirstSeen:     now,
+			LastSeen:      now,
+		}
+
+		old, ok := previous[doc.ID]
+		switch {
+		case !ok:
+			addChange(doc, types.ChangeAdded, "", historyDate(doc.ExpiryDate))
+		default:
+			current.FirstSeen = old.FirstSeen
+			if old.Deleted {
+				addChange(doc, types.ChangeRestored, "", historyDate(doc.ExpiryDate))
+			}
+			if !old.ExpiryDate.Equal(doc.ExpiryDate) {
+				change := types.ChangeRenewed
+				if doc.ExpiryDate.Before(old.ExpiryDate) {
+					change = types.ChangeExpiryChanged
+				}
+				addChange(doc, change, historyDate(old.ExpiryDate), historyDate(doc.ExpiryDate))
+			}
+			if !old.Deleted && old.Status != doc.Status {
+				addChange(doc, types.ChangeStatusChanged, old.Status, doc.Status)
+			}
+		}
+		updated = append(updated, current)
+	}
+
+	// Keep documents that left the sheet, flagged, so their history stays
+	// reachable and a returning row is recognised
+	for _, old := range stored {
+		if seen[old.ID] || old.Deleted {
+			continue
+		}
+		addChange(&old.Document, types.ChangeDeleted, historyDate(old.ExpiryDate), "")
+		old.Deleted = true
+		updated = append(updated, old)
+	}
+
+	if err := dr.databaseStore.AddDocumentChanges(changes); err != nil {
+		return nil, err
+	}
+	if err := dr.databaseStore.PutDocuments(updated); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func historyDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"lambda/types"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDocumentRecorder(t *testing.T) {
+	dbStore, _ := newTestStore(t)
+	recorder := NewDocumentRecorder(dbStore)
+	spreadsheet := &types.Spreadsheet{UserID: "user-1", SheetSettings: types.SheetSettings{SpreadsheetID: "sheet-1"}}
+	doc := func(id, expiry, status string) *types.Document {
+		expiryDate, _ := time.Parse("2006-01-02", expiry)
+		return &types.Document{ID: id, DocumentName: id, ExpiryDate: expiryDate, Status: status}
+	}
+	first := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+
+	// change is a recorded change without its spreadsheet and time
+	type change struct {
+		Document, Change, Old, New string
+	}
+	tests := []struct {
+		name        string
+		docs        []*types.Document
+		wantChanges []change
+		wantDeleted []string
+	}{
+		{
+			name: "first check adds every document",
+			docs: []*types.Document{doc("passport", "2025-04-01", types.StatusExpiringSoon), doc("visa", "2026-01-31", types.StatusValid), doc("lease", "2025-09-01", types.StatusValid)},
+			wantChanges: []change{
+				{"passport", types.ChangeAdded, "", "2025-04-01"},
+				{"visa", types.ChangeAdded, "", "2026-01-31"},
+				{"lease", types.ChangeAdded, "", "2025-09-01"},
+			},
+		},
+		{
+			name: "unchanged documents",
+			docs: []*types.Document{doc("passport", "2025-04-01", types.StatusExpiringSoon), doc("visa", "2026-01-31", types.StatusValid), doc("lease", "2025-09-01", types.StatusValid)},
+		},
+		{
+			name: "renewed, moved earlier and removed",
+			docs: []*types.Document{doc("passport", "2035-04-01", types.StatusValid), doc("visa", "2025-12-31", types.StatusValid)},
+			wantChanges: []change{
+				{"passport", types.ChangeRenewed, "2025-04-01", "2035-04-01"},
+				{"passport", types.ChangeStatusChanged, types.StatusExpiringSoon, types.StatusValid},
+				{"visa", types.ChangeExpiryChanged, "2026-01-31", "2025-12-31"},
+				{"lease", types.ChangeDeleted, "2025-09-01", ""},
+			},
+			wantDeleted: []string{"lease"},
+		},
+		{
+			name:        "removed document stays removed",
+			docs:        []*types.Document{doc("passport", "2035-04-01", types.StatusValid), doc("visa", "2025-12-31", types.StatusValid)},
+			wantDeleted: []string{"lease"},
+		},
+		{
+			name: "removed document returns",
+			docs: []*types.Document{doc("passport", "2035-04-01", types.StatusValid), doc("visa", "2025-12-31", types.StatusValid), doc("lease", "2026-09-01", types.StatusValid)},
+			wantChanges: []change{
+				{"lease", types.ChangeRestored, "", "2026-09-01"},
+				{"lease", types.ChangeRenewed, "2025-09-01", "2026-09-01"},
+			},
+		},
+	}
+	for i, tt := range tests {
+		now := first.AddDate(0, 0, i)
+		changes, err := recorder.Record(spreadsheet, tt.docs, now)
+		if err != nil {
+			t.Fatalf("%s: Record: %v", tt.name, err)
+		}
+		var got []change
+		for _, c := range changes {
+			if c.UserID != "user-1" || c.SpreadsheetID != "sheet-1" || !c.ChangedAt.Equal(now) {
+				t.Errorf("%s: change %+v not recorded for sheet-1 at %v", tt.name, c, now)
+			}
+			got = append(got, change{c.DocumentID, c.Change, c.OldValue, c.NewValue})
+		}
+		if !reflect.DeepEqual(got, tt.wantChanges) {
+			t.Errorf("%s: changes =\n%v\nwant\n%v", tt.name, got, tt.wantChanges)
+		}
+
+		stored, err := dbStore.ListDocuments("user-1", "sheet-1")
+		if err != nil {
+			t.Fatalf("%s: ListDocuments: %v", tt.name, err)
+		}
+		var deleted []string
+		for _, s := range stored {
+			if !s.FirstSeen.Equal(first) {
+				t.Errorf("%s: %s first seen %v, want %v", tt.name, s.ID, s.FirstSeen, first)
+			}
+			if s.Deleted {
+				deleted = append(deleted, s.ID)
+			} else if !s.LastSeen.Equal(now) {
+				t.Errorf("%s: %s last seen %v, want %v", tt.name, s.ID, s.LastSeen, now)
+			}
+		}
+		if len(stored) != 3 || !reflect.DeepEqual(deleted, tt.wantDeleted) {
+			t.Errorf("%s: %d documents stored, deleted %v, want 3 and %v", tt.name, len(stored), deleted, tt.wantDeleted)
+		}
+	}
+
+	history, err := dbStore.DocumentHistory("user-1", "sheet-1", "lease")
+	if err != nil {
+		t.Fatalf("DocumentHistory: %v", err)
+	}
+	var kinds []string
+	for _, c := range history {
+		kinds = append(kinds, c.Change)
+	}
+	if want := []string{types.ChangeAdded, types.ChangeDeleted, types.ChangeRenewed, types.ChangeRestored}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("history of lease = %v, want %v", kinds, want)
+	}
+}
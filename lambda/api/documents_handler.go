@@ -0,0 +1,331 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"lambda/database"
+	"lambda/types"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Page sizes of GET /documents.
+const (
+	defaultDocumentsLimit = 50
+	maxDocumentsLimit     = 500
+)
+
+// DocumentsHandler serves the results of the last check of each of a user's
+// spreadsheets to the dashboard. It runs behind the token middleware.
+type DocumentsHandler struct {
+	databaseStore *database.DynamoDBStore
+}
+
+func NewDocumentsHandler(dbStore *database.DynamoDBStore) *DocumentsHandler {
+	return &DocumentsHandler{
+		databaseStore: dbStore,
+	}
+}
+
+// documentView is a document together with the spreadsheet it came from.
+type documentView struct {
+	*types.Document
+	SpreadsheetID    string `json:"spreadsheet_id"`
+	SpreadsheetTitle string `json:"spreadsheet_title"`
+}
+
+type documentsResponse struct {
+	Documents  []documentView `json:"documents"`
+	Total      int            `json:"total"`                 // Matching documents across all pages
+	NextOffset *int           `json:"next_offset,omitempty"` // Offset of the next page, if any
+}
+
+type spreadsheetSummary struct {
+	SpreadsheetID string           `json:"spreadsheet_id"`
+	Title         string           `json:"title"`
+	CheckedAt     time.Time        `json:"checked_at"`
+	Counts        map[string]int   `json:"counts"`
+	Issues        int              `json:"issues"`
+	RowIssues     []types.RowIssue `json:"row_issues"` // Rows skipped or partly ignored by the last check
+}
+
+type summaryResponse struct {
+	CheckedAt    time.Time            `json:"checked_at"` // Most recent check of any spreadsheet
+	Total        int                  `json:"total"`
+	Counts       map[string]int       `json:"counts"`
+	Issues       int                  `json:"issues"`
+	Spreadsheets []spreadsheetSummary `json:"spreadsheets"`
+	CalendarFeed string               `json:"calendar_feed,omitempty"` // iCalendar feed URL to subscribe to
+}
+
+// ListDocuments handles GET /documents. Query parameters:
+//   - spreadsheet_id: only documents of this spreadsheet
+//   - status: repeatable or comma separated statuses to include
+//   - sort: "expiry" (default), "-expiry" or "name"
+//   - limit, offset: pagination
+func (dh *DocumentsHandler) ListDocuments(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	corsHeaders := dashboardHeaders()
+	params := request.QueryStringParameters
+
+	limit, err := queryInt(params, "limit", defaultDocumentsLimit)
+	if err != nil || limit < 1 || limit > maxDocumentsLimit {
+		return errorResponse(http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxDocumentsLimit), corsHeaders), nil
+	}
+	offset, err := queryInt(params, "offset", 0)
+	if err != nil || offset < 0 {
+		return errorResponse(http.StatusBadRequest, "offset must not be negative", corsHeaders), nil
+	}
+	statuses, err := statusFilter(request)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err.Error(), corsHeaders), nil
+	}
+	less, err := documentOrder(params["sort"])
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err.Error(), corsHeaders), nil
+	}
+
+	results, errResponse := dh.loadResults(ctx, strings.TrimSpace(params["spreadsheet_id"]), corsHeaders)
+	if errResponse != nil {
+		return *errResponse, nil
+	}
+
+	documents := []documentView{}
+	for _, result := range results {
+		for _, doc := range result.Documents {
+			if len(statuses) > 0 && !statuses[doc.Status] {
+				continue
+			}
+			documents = append(documents, documentView{
+				Document:         doc,
+				SpreadsheetID:    result.SpreadsheetID,
+				SpreadsheetTitle: result.Title,
+			})
+		}
+	}
+	sort.SliceStable(documents, func(i, j int) bool {
+		return less(documents[i], documents[j])
+	})
+
+	response := documentsResponse{
+		Documents: []documentView{},
+		Total:     len(documents),
+	}
+	if offset < len(documents) {
+		end := offset + limit
+		if end < len(documents) {
+			response.NextOffset = &end
+		} else {
+			end = len(documents)
+		}
+		response.Documents = documents[offset:end]
+	}
+	return jsonResponse(response, corsHeaders), nil
+}
+
+// Summary handles GET /summary, counting documents per status for each
+// spreadsheet and overall, and listing the rows the last check of each
+// spreadsheet could not track.
+func (dh *DocumentsHandler) Summary(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	corsHeaders := dashboardHeaders()
+
+	results, errResponse := dh.loadResults(ctx, strings.TrimSpace(request.QueryStringParameters["spreadsheet_id"]), corsHeaders)
+	if errResponse != nil {
+		return *errResponse, nil
+	}
+
+	response := summaryResponse{
+		Counts:       emptyStatusCounts(),
+		Spreadsheets: []spreadsheetSummary{},
+	}
+	for _, result := range results {
+		summary := spreadsheetSummary{
+			SpreadsheetID: result.SpreadsheetID,
+			Title:         result.Title,
+			CheckedAt:     result.CheckedAt,
+			Counts:        emptyStatusCounts(),
+			Issues:        len(result.Issues),
+			RowIssues:     result.Issues,
+		}
+		if summary.RowIssues == nil {
+			summary.RowIssues = []types.RowIssue{}
+		}
+		for _, doc := range result.Documents {
+			summary.Counts[doc.Status]++
+			response.Counts[doc.Status]++
+		}
+		response.Total += len(result.Documents)
+		response.Issues += len(result.Issues)
+		if result.CheckedAt.After(response.CheckedAt) {
+			response.CheckedAt = result.CheckedAt
+		}
+		response.Spreadsheets = append(response.Spreadsheets, summary)
+	}
+	sort.Slice(response.Spreadsheets, func(i, j int) bool {
+		return response.Spreadsheets[i].Title < response.Spreadsheets[j].Title
+	})
+	if token, ok := ctx.Value("user_token").(*types.Token); ok {
+		response.CalendarFeed = calendarFeedURL(dh.databaseStore, request, token.UserID)
+	}
+	return jsonResponse(response, corsHeaders), nil
+}
+
+// loadResults returns the cached results of the signed in user, optionally
+// limited to one spreadsheet, with statuses brought up to date. Results of
+// spreadsheets that are no longer registered are left out.
+func (dh *DocumentsHandler) loadResults(ctx context.Context, spreadsheetID string, corsHeaders map[string]string) ([]*types.CheckResult, *events.APIGatewayProxyResponse) {
+	token, ok := ctx.Value("user_token").(*types.Token)
+	if !ok {
+		response := errorResponse(http.StatusUnauthorized, "Unauthorized: Missing user identification", corsHeaders)
+		return nil, &response
+	}
+
+	cached, err := currentResults(dh.databaseStore, token.UserID, time.Now())
+	if err != nil {
+		fmt.Printf("loading results failed for user %s: %v\n", token.UserID, err)
+		response := errorResponse(http.StatusInternalServerError, "error loading documents", corsHeaders)
+		return nil, &response
+	}
+
+	var results []*types.CheckResult
+	for _, c := range cached {
+		if spreadsheetID == "" || c.Result.SpreadsheetID == spreadsheetID {
+			results = append(results, c.Result)
+		}
+	}
+	if spreadsheetID == "" || len(results) > 0 {
+		return results, nil
+	}
+	response := errorResponse(http.StatusNotFound, "spreadsheet not found", corsHeaders)
+	return nil, &response
+}
+
+// cachedResult is the cached result of a registered spreadsheet.
+type cachedResult struct {
+	Spreadsheet *types.Spreadsheet
+	Result      *types.CheckResult
+}
+
+// currentResults returns the cached results of a user's registered
+// spreadsheets. Statuses are evaluated again as of now, since days remaining
+// change daily while the sheets are only read on each scheduled run.
+func currentResults(dbStore *database.DynamoDBStore, userID string, now time.Time) ([]*cachedResult, error) {
+	spreadsheets, err := dbStore.ListSpreadsheets(userID)
+	if err != nil {
+		return nil, err
+	}
+	results, err := dbStore.ListResults(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	registered := map[string]*types.Spreadsheet{}
+	for _, spreadsheet := range spreadsheets {
+		registered[spreadsheet.SpreadsheetID] = spreadsheet
+	}
+
+	var current []*cachedResult
+	for _, result := range results {
+		spreadsheet, ok := registered[result.SpreadsheetID]
+		if !ok {
+			continue
+		}
+		NewStatusEngine(now, &spreadsheet.SheetSettings).Evaluate(result.Documents)
+		current = append(current, &cachedResult{Spreadsheet: spreadsheet, Result: result})
+	}
+	return current, nil
+}
+
+// statusFilter reads the statuses to include, matching them case
+// insensitively against the known statuses.
+func statusFilter(request events.APIGatewayProxyRequest) (map[string]bool, error) {
+	values := request.MultiValueQueryStringParameters["status"]
+	if len(values) == 0 && request.QueryStringParameters["status"] != "" {
+		values = []string{request.QueryStringParameters["status"]}
+	}
+
+	statuses := map[string]bool{}
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			status, ok := lookupStatus(name)
+			if !ok {
+				return nil, fmt.Errorf("unknown status %q", name)
+			}
+			statuses[status] = true
+		}
+	}
+	return statuses, nil
+}
+
+func lookupStatus(name string) (string, bool) {
+	normalized := normalizeHeader(name)
+	for _, status := range statusOrder {
+		if normalizeHeader(status) == normalized {
+			return status, true
+		}
+	}
+	return "", false
+}
+
+// documentOrder returns the comparison for the requested sort order.
+func documentOrder(order string) (func(a, b documentView) bool, error) {
+	switch strings.TrimSpace(order) {
+	case "", "expiry":
+		return func(a, b documentView) bool { return a.ExpiryDate.Before(b.ExpiryDate) }, nil
+	case "-expiry":
+		return func(a, b documentView) bool { return a.ExpiryDate.After(b.ExpiryDate) }, nil
+	case "name":
+		return func(a, b documentView) bool {
+			return strings.ToLower(a.DocumentName) < strings.ToLower(b.DocumentName)
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown sort order %q", order)
+	}
+}
+
+func emptyStatusCounts() map[string]int {
+	counts := map[string]int{}
+	for _, status := range statusOrder {
+		counts[status] = 0
+	}
+	return counts
+}
+
+// queryInt reads an integer query parameter, returning fallback when absent.
+func queryInt(params map[string]string, name string, fallback int) (int, error) {
+	value := strings.TrimSpace(params[name])
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func dashboardHeaders() map[string]string {
+	return map[string]string{
+		"Content-Type":                     "application/json",
+		"Access-Control-Allow-Origin":      "http://localhost:3000",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET,POST,PUT,DELETE,OPTIONS",
+		"Access-Control-Allow-Headers":     "Content-Type,X-Amz-Date,Authorization,X-Api-Key,X-Amz-Security-Token",
+	}
+}
+
+func jsonResponse(body interface{}, headers map[string]string) events.APIGatewayProxyResponse {
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return errorResponse(http.StatusInternalServerError, "error encoding response", headers)
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: http.StatusOK,
+		Headers:    headers,
+		Body:       string(raw),
+	}
+}
@@ -0,0 +1,250 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"lambda/types"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// newTestDocumentsHandler stores two spreadsheets of user-1 with their last
+// results, plus a result of a spreadsheet that is no longer registered.
+func newTestDocumentsHandler(t *testing.T) (*DocumentsHandler, context.Context) {
+	t.Helper()
+	dbStore, _ := newTestStore(t)
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	doc := func(name string, days int) *types.Document {
+		d := &types.Document{ID: strings.ToLower(name), DocumentName: name, Sheet: "Sheet1"}
+		if days != 0 {
+			d.ExpiryDate = today.AddDate(0, 0, days)
+		}
+		return d
+	}
+
+	for _, spreadsheetID := range []string{"sheet-1", "sheet-2"} {
+		err := dbStore.CreateSpreadsheet(&types.Spreadsheet{UserID: "user-1", SheetSettings: types.SheetSettings{SpreadsheetID: spreadsheetID, WarningDays: []int{30}}})
+		if err != nil {
+			t.Fatalf("CreateSpreadsheet: %v", err)
+		}
+	}
+	results := []*types.CheckResult{
+		{UserID: "user-1", SpreadsheetID: "sheet-1", SheetResult: types.SheetResult{
+			Title:     "Family",
+			Documents: []*types.Document{doc("Passport", -10), doc("visa", 10), doc("Lease", 400)},
+			Issues:    []types.RowIssue{{Sheet: "Sheet1", Row: 7, Column: "Expiry Date", Value: "soon", Reason: `unrecognized date format "soon"`}},
+		}},
+		{UserID: "user-1", SpreadsheetID: "sheet-2", SheetResult: types.SheetResult{
+			Title:     "Office",
+			Documents: []*types.Document{doc("Insurance", 20), doc("Permit", 0)},
+		}},
+		{UserID: "user-1", SpreadsheetID: "removed", SheetResult: types.SheetResult{
+			Title:     "Removed",
+			Documents: []*types.Document{doc("Licence", 5)},
+		}},
+	}
+	for _, result := range results {
+		result.CheckedAt = today
+		if err := dbStore.SaveResult(result); err != nil {
+			t.Fatalf("SaveResult: %v", err)
+		}
+	}
+	ctx := context.WithValue(context.Background(), "user_token", &types.Token{UserID: "user-1"})
+	return NewDocumentsHandler(dbStore), ctx
+}
+
+func TestListDocuments(t *testing.T) {
+	handler, ctx := newTestDocumentsHandler(t)
+
+	tests := []struct {
+		name           string
+		params         map[string]string
+		multi          map[string][]string
+		wantStatus     int
+		wantNames      []string
+		wantTotal      int
+		wantNextOffset int // 0 means no next page
+	}{
+		{
+			name:       "defaults sort by expiry",
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"Permit", "Passport", "visa", "Insurance", "Lease"},
+			wantTotal:  5,
+		},
+		{
+			name:       "latest expiry first",
+			params:     map[string]string{"sort": "-expiry"},
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"Lease", "Insurance", "visa", "Passport", "Permit"},
+			wantTotal:  5,
+		},
+		{
+			name:       "by name ignoring case",
+			params:     map[string]string{"sort": "name"},
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"Insurance", "Lease", "Passport", "Permit", "visa"},
+			wantTotal:  5,
+		},
+		{
+			name:       "one status",
+			params:     map[string]string{"status": "expired"},
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"Passport"},
+			wantTotal:  1,
+		},
+		{
+			name:       "comma separated statuses",
+			params:     map[string]string{"status": "Expiring Soon, unknown"},
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"Permit", "visa", "Insurance"},
+			wantTotal:  3,
+		},
+		{
+			name:       "repeated statuses",
+			params:     map[string]string{"status": "valid"},
+			multi:      map[string][]string{"status": {"expired", "valid"}},
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"Passport", "Lease"},
+			wantTotal:  2,
+		},
+		{
+			name:       "one spreadsheet",
+			params:     map[string]string{"spreadsheet_id": "sheet-2"},
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"Permit", "Insurance"},
+			wantTotal:  2,
+		},
+		{
+			name:           "first page",
+			params:         map[string]string{"limit": "2"},
+			wantStatus:     http.StatusOK,
+			wantNames:      []string{"Permit", "Passport"},
+			wantTotal:      5,
+			wantNextOffset: 2,
+		},
+		{
+			name:       "last page",
+			params:     map[string]string{"limit": "2", "offset": "4"},
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"Lease"},
+			wantTotal:  5,
+		},
+		{
+			name:       "page ending on the last document",
+			params:     map[string]string{"limit": "3", "offset": "2"},
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"visa", "Insurance", "Lease"},
+			wantTotal:  5,
+		},
+		{
+			name:       "offset past the end",
+			params:     map[string]string{"offset": "5"},
+			wantStatus: http.StatusOK,
+			wantTotal:  5,
+		},
+		{
+			name:       "largest limit",
+			params:     map[string]string{"limit": "500"},
+			wantStatus: http.StatusOK,
+			wantNames:  []string{"Permit", "Passport", "visa", "Insurance", "Lease"},
+			wantTotal:  5,
+		},
+		{name: "zero limit", params: map[string]string{"limit": "0"}, wantStatus: http.StatusBadRequest},
+		{name: "limit too large", params: map[string]string{"limit": "501"}, wantStatus: http.StatusBadRequest},
+		{name: "limit not a number", params: map[string]string{"limit": "abc"}, wantStatus: http.StatusBadRequest},
+		{name: "negative offset", params: map[string]string{"offset": "-1"}, wantStatus: http.StatusBadRequest},
+		{name: "offset not a number", params: map[string]string{"offset": "1.5"}, wantStatus: http.StatusBadRequest},
+		{name: "unknown status", params: map[string]string{"status": "expired,lost"}, wantStatus: http.StatusBadRequest},
+		{name: "unknown sort order", params: map[string]string{"sort": "status"}, wantStatus: http.StatusBadRequest},
+		{name: "unknown spreadsheet", params: map[string]string{"spreadsheet_id": "sheet-3"}, wantStatus: http.StatusNotFound},
+		{name: "unregistered spreadsheet", params: map[string]string{"spreadsheet_id": "removed"}, wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := handler.ListDocuments(ctx, events.APIGatewayProxyRequest{
+				QueryStringParameters:           tt.params,
+				MultiValueQueryStringParameters: tt.multi,
+			})
+			if err != nil {
+				t.Fatalf("ListDocuments: %v", err)
+			}
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", response.StatusCode, tt.wantStatus, response.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var body documentsResponse
+			if err := json.Unmarshal([]byte(response.Body), &body); err != nil {
+				t.Fatalf("decoding %s: %v", response.Body, err)
+			}
+			var names []string
+			for _, doc := range body.Documents {
+				names = append(names, doc.DocumentName)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+				t.Errorf("documents = %v, want %v", names, tt.wantNames)
+			}
+			if body.Total != tt.wantTotal {
+				t.Errorf("total = %d, want %d", body.Total, tt.wantTotal)
+			}
+			switch {
+			case tt.wantNextOffset == 0 && body.NextOffset != nil:
+				t.Errorf("next offset = %d, want none", *body.NextOffset)
+			case tt.wantNextOffset != 0 && (body.NextOffset == nil || *body.NextOffset != tt.wantNextOffset):
+				t.Errorf("next offset = %v, want %d", body.NextOffset, tt.wantNextOffset)
+			}
+		})
+	}
+}
+
+func TestListDocumentsRequiresUser(t *testing.T) {
+	handler, _ := newTestDocumentsHandler(t)
+	response, err := handler.ListDocuments(context.Background(), events.APIGatewayProxyRequest{})
+	if err != nil {
+		t.Fatalf("ListDocuments: %v", err)
+	}
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", response.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestDocumentsSummary(t *testing.T) {
+	handler, ctx := newTestDocumentsHandler(t)
+	response, err := handler.Summary(ctx, events.APIGatewayProxyRequest{})
+	if err != nil {
+		t.Fatalf("Summary: %v", err)
+	}
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d: %s", response.StatusCode, response.Body)
+	}
+
+	var body summaryResponse
+	if err := json.Unmarshal([]byte(response.Body), &body); err != nil {
+		t.Fatalf("decoding %s: %v", response.Body, err)
+	}
+	if body.Total != 5 || body.Issues != 1 {
+		t.Errorf("total = %d, issues = %d, want 5 and 1", body.Total, body.Issues)
+	}
+	wantCounts := map[string]int{types.StatusExpired: 1, types.StatusExpiringSoon: 2, types.StatusValid: 1, types.StatusUnknown: 1}
+	for status, want := range wantCounts {
+		if body.Counts[status] != want {
+			t.Errorf("%s count = %d, want %d", status, body.Counts[status], want)
+		}
+	}
+	if len(body.Spreadsheets) != 2 || body.Spreadsheets[0].Title != "Family" || body.Spreadsheets[1].Title != "Office" {
+		t.Fatalf("spreadsheets = %+v, want Family and Office", body.Spreadsheets)
+	}
+	family, office := body.Spreadsheets[0], body.Spreadsheets[1]
+	if len(family.RowIssues) != 1 || family.RowIssues[0].Row != 7 || family.RowIssues[0].Value != "soon" {
+		t.Errorf("row issues of Family = %+v, want row 7", family.RowIssues)
+	}
+	if office.RowIssues == nil || len(office.RowIssues) != 0 {
+		t.Errorf("row issues of Office = %#v, want an empty list", office.RowIssues)
+	}
+}
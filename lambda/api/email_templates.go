@@ -0,0 +1,254 @@
+package api
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"lambda/types"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Built-in templates. A deployment can override either file by placing one
+// with the same name in the directory named by EMAIL_TEMPLATE_DIR.
+//
+//go:embed templates/*.tmpl
+var builtinTemplates embed.FS
+
+const (
+	summaryTextTemplate = "summary.txt.tmpl"
+	summaryHTMLTemplate = "summary.html.tmpl"
+)
+
+// Colors used for each status in HTML emails.
+var statusColors = map[string]struct{ Text, Background string }{
+	types.StatusExpired:      {"#b3261e", "#fdecea"},
+	types.StatusExpiringSoon: {"#9a6700", "#fff8e1"},
+	types.StatusValid:        {"#1e7b34", "#ffffff"},
+	types.StatusUnknown:      {"#555555", "#f5f5f5"},
+}
+
+// Order in which statuses are counted in the summary header.
+var statusOrder = []string{types.StatusExpired, types.StatusExpiringSoon, types.StatusValid, types.StatusUnknown}
+
+var templateFuncs = map[string]interface{}{
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format("2006-01-02")
+	},
+	"statusColor": func(status string) string {
+		return statusColors[status].Text
+	},
+	"statusBackground": func(status string) string {
+		return statusColors[status].Background
+	},
+}
+
+// summaryView is the data passed to the summary templates.
+type summaryView struct {
+	Title     string
+	Documents []*types.Document
+	Issues    []types.RowIssue
+	Counts    []statusCount
+}
+
+type statusCount struct {
+	Status string
+	Count  int
+}
+
+// renderedEmail holds both bodies of a multipart email.
+type renderedEmail struct {
+	Text string
+	HTML string
+}
+
+// renderDocumentEmail renders a list of documents, sorted by expiry date
+// soonest first, followed by the rows that could not be tracked.
+func renderDocumentEmail(title string, documents []*types.Document, issues []types.RowIssue) (*renderedEmail, error) {
+	docs := append([]*types.Document{}, documents...)
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].ExpiryDate.Before(docs[j].ExpiryDate)
+	})
+
+	counts := map[string]int{}
+	for _, doc := range docs {
+		counts[doc.Status]++
+	}
+	view := summaryView{
+		Title:     title,
+		Documents: docs,
+		Issues:    issues,
+	}
+	for _, status := range statusOrder {
+		if counts[status] > 0 {
+			view.Counts = append(view.Counts, statusCount{Status: status, Count: counts[status]})
+		}
+	}
+
+	textSource, err := loadTemplate(summaryTextTemplate)
+	if err != nil {
+		return nil, err
+	}
+	textTmpl, err := texttemplate.New(summaryTextTemplate).Funcs(templateFuncs).Parse(textSource)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", summaryTextTemplate, err)
+	}
+
+	htmlSource, err := loadTemplate(summaryHTMLTemplate)
+	if err != nil {
+		return nil, err
+	}
+	htmlTmpl, err := htmltemplate.New(summaryHTMLTemplate).Funcs(templateFuncs).Parse(htmlSource)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", summaryHTMLTemplate, err)
+	}
+
+	var text, html bytes.Buffer
+	if err := textTmpl.Execute(&text, view); err != nil {
+		return nil, fmt.Errorf("error rendering %s: %w", summaryTextTemplate, err)
+	}
+	if err := htmlTmpl.Execute(&html, view); err != nil {
+		return nil, fmt.Errorf("error rendering %s: %w", summaryHTMLTemplate, err)
+	}
+
+	return &renderedEmail{
+		Text: text.String(),
+		HTML: html.String(),
+	}, nil
+}
+
+// summaryEmail renders the summary of a processed sheet. The subject only
+// names the spreadsheet so mail clients keep the summaries in one thread.
+func summaryEmail(result *types.SheetResult) (string, *renderedEmail, error) {
+	email, err := renderDocumentEmail("Document Summary", result.Documents, result.Issues)
+	if err != nil {
+		return "", nil, err
+	}
+
+	subject := "Document Summary"
+	if result.Title != "" {
+		subject = fmt.Sprintf("Document Summary: %s", result.Title)
+	}
+	return subject, email, nil
+}
+
+// reminderEmail renders the documents that crossed a reminder threshold.
+func reminderEmail(docs []*types.Document) (string, *renderedEmail, error) {
+	email, err := renderDocumentEmail("Document Reminders", docs, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return remindersHeadline(docs), email, nil
+}
+
+// emailHeaders are the headers of a notification email.
+type emailHeaders struct {
+	From      string // Already formatted address
+	To        []string
+	Cc        []string
+	ReplyTo   string
+	Subject   string
+	MessageID string // Left to the sending server when empty
+	InReplyTo string // Message-ID of the message this one replies to
+}
+
+// composeEmail writes a complete message with the given headers and a
+// multipart body, ready to be handed to Gmail or an SMTP server.
+func composeEmail(headers *emailHeaders, email *renderedEmail) ([]byte, error) {
+	contentType, body, err := buildMultipartBody(email)
+	if err != nil {
+		return nil, fmt.Errorf("error building email body: %w", err)
+	}
+
+	var message bytes.Buffer
+	message.WriteString(fmt.Sprintf("From: %s\r\n", headers.From))
+	message.WriteString(fmt.Sprintf("To: %s\r\n", formatAddressList(headers.To)))
+	if len(headers.Cc) > 0 {
+		message.WriteString(fmt.Sprintf("Cc: %s\r\n", formatAddressList(headers.Cc)))
+	}
+	if headers.ReplyTo != "" {
+		message.WriteString(fmt.Sprintf("Reply-To: %s\r\n", formatAddressList([]string{headers.ReplyTo})))
+	}
+	message.WriteString(fmt.Sprintf("Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", headers.Subject)))
+	if headers.MessageID != "" {
+		message.WriteString(fmt.Sprintf("Message-ID: %s\r\n", headers.MessageID))
+		message.WriteString(fmt.Sprintf("Date: %s\r\n", time.Now().Format(time.RFC1123Z)))
+	}
+	if headers.InReplyTo != "" {
+		message.WriteString(fmt.Sprintf("In-Reply-To: %s\r\n", headers.InReplyTo))
+		message.WriteString(fmt.Sprintf("References: %s\r\n", headers.InReplyTo))
+	}
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString(fmt.Sprintf("Content-Type: %s\r\n\r\n", contentType))
+	message.Write(body)
+	return message.Bytes(), nil
+}
+
+// loadTemplate returns the deployment's override of a template when one
+// exists, otherwise the built-in version.
+func loadTemplate(name string) (string, error) {
+	if dir := os.Getenv("EMAIL_TEMPLATE_DIR"); dir != "" {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return string(content), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("error reading template %s: %w", name, err)
+		}
+	}
+
+	content, err := builtinTemplates.ReadFile("templates/" + name)
+	if err != nil {
+		return "", fmt.Errorf("error reading template %s: %w", name, err)
+	}
+	return string(content), nil
+}
+
+// buildMultipartBody writes a multipart/alternative body holding the text
+// and HTML versions of an email and returns its Content-Type header.
+func buildMultipartBody(email *renderedEmail) (string, []byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	// Clients show the last part they understand, so HTML goes last
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=UTF-8", email.Text},
+		{"text/html; charset=UTF-8", email.HTML},
+	} {
+		partWriter, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		encoder := quotedprintable.NewWriter(partWriter)
+		if _, err := encoder.Write([]byte(strings.ReplaceAll(part.content, "\r\n", "\n"))); err != nil {
+			return "", nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return "", nil, err
+	}
+
+	contentType := mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": writer.Boundary()})
+	return contentType, body.Bytes(), nil
+}
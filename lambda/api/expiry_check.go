@@ -0,0 +1,225 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"lambda/api/auth"
+	"lambda/database"
+	"lambda/types"
+	"time"
+)
+
+// ExpiryCheck processes one spreadsheet end to end: read the documents,
+// compute their status, write it back when enabled and send notifications.
+type ExpiryCheck struct {
+	Services    *GoogleServices
+	UserInfo    *types.UserInfo
+	Spreadsheet *types.Spreadsheet
+
+	databaseStore *database.DynamoDBStore
+
+	// Reminders deduplicates scheduled notifications. Without it the full
+	// summary is sent on every run, as when a user logs in.
+	Reminders *ReminderTracker
+
+	// SyncCalendar keeps the expiry dates in the user's calendar in step with
+	// the sheet. Only scheduled runs sync, since a large sheet takes longer
+	// than the API allows a sign in to take.
+	SyncCalendar bool
+}
+
+func NewExpiryCheck(services *GoogleServices, userInfo *types.UserInfo, spreadsheet *types.Spreadsheet, dbStore *database.DynamoDBStore) *ExpiryCheck {
+	return &ExpiryCheck{
+		Services:      services,
+		UserInfo:      userInfo,
+		Spreadsheet:   spreadsheet,
+		databaseStore: dbStore,
+	}
+}
+
+// Run performs the check as of now and returns the processed sheet.
+func (ec *ExpiryCheck) Run(now time.Time) (*types.SheetResult, error) {
+	settings := &ec.Spreadsheet.SheetSettings
+
+	// Process spreadsheet data
+	sheetProcessor := NewSheetProcessor(ec.Services.SheetsService)
+	result, err := sheetProcessor.ProcessSheetData(settings)
+	if err != nil {
+		return nil, fmt.Errorf("error processing spreadsheet data: %w", err)
+	}
+
+	// Derive each document's status from its expiry date
+	NewStatusEngine(now, settings).Evaluate(result.Documents)
+
+	// Write the computed status back into the sheet when asked to. A sheet
+	// that cannot be written to still gets its notifications.
+	if settings.WriteBack {
+		sheetWriter := NewSheetWriter(ec.Services.SheetsService)
+		if err := sheetWriter.WriteStatus(settings, result, now); err != nil {
+			fmt.Printf("failed to write status to %s: %v\n", settings.SpreadsheetID, err)
+		}
+	}
+
+	// Cache the result for the dashboard and calendar feed
+	if err := ec.databaseStore.SaveResult(&types.CheckResult{
+		UserID:        ec.Spreadsheet.UserID,
+		SpreadsheetID: settings.SpreadsheetID,
+		SheetResult:   *result,
+		CheckedAt:     now,
+	}); err != nil {
+		fmt.Printf("failed to cache result of %s: %v\n", settings.SpreadsheetID, err)
+	}
+
+	// Keep the documents and their history of renewals and status changes
+	changes, err := NewDocumentRecorder(ec.databaseStore).Record(ec.Spreadsheet, result.Documents, now)
+	if err != nil {
+		fmt.Printf("failed to record documents of %s: %v\n", settings.SpreadsheetID, err)
+	} else if len(changes) > 0 {
+		fmt.Printf("recorded %d document change(s) in %s\n", len(changes), settings.SpreadsheetID)
+	}
+
+	// Keep the expiry dates in the user's calendar in step with the sheet
+	if ec.SyncCalendar && ec.Spreadsheet.Calendar.Enabled {
+		calendarSync := NewCalendarSync(ec.Services.CalendarService, &ec.Spreadsheet.Calendar)
+		if err := calendarSync.Sync(settings.SpreadsheetID, result.Documents); err != nil {
+			fmt.Printf("failed to sync calendar of %s: %v\n", settings.SpreadsheetID, err)
+		}
+	}
+
+	// Email always goes out; configured chat and webhook channels get the
+	// same notifications
+	notifications := &ec.Spreadsheet.Notifications
+	thread := ec.Spreadsheet.SummaryThread
+	emailSender, err := ec.emailSender(&thread)
+	if err != nil {
+		return nil, err
+	}
+
+	notifiers := []Notifier{emailSender}
+	for _, channel := range notifications.Channels {
+		notifier, err := NewChannelNotifier(settings.SpreadsheetID, channel)
+		if err != nil {
+			fmt.Printf("skipping notification channel: %v\n", err)
+			continue
+		}
+		notifiers = append(notifiers, notifier)
+	}
+
+	if ec.Reminders == nil {
+		// Send document summary
+		if notifications.EmailSummary {
+			if err := ec.sendSummary(notifiers, result); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		if err := ec.sendReminders(notifiers, result); err != nil {
+			return nil, err
+		}
+		if err := ec.sendWeeklySummary(notifiers, result, now); err != nil {
+			return nil, err
+		}
+	}
+
+	// Remember the summary thread so the next summary replies to it
+	if thread != ec.Spreadsheet.SummaryThread {
+		ec.Spreadsheet.SummaryThread = thread
+		if err := ec.databaseStore.UpdateSpreadsheet(ec.Spreadsheet); err != nil {
+			// Only threading is lost; the next summary starts a new thread
+			fmt.Printf("failed to save summary thread: %v\n", err)
+		}
+	}
+	return result, nil
+}
+
+// emailSender returns the email notifier of the deployment's backend.
+func (ec *ExpiryCheck) emailSender(thread *types.EmailThread) (Notifier, error) {
+	notifications := &ec.Spreadsheet.Notifications
+
+	if EmailBackend() == emailBackendSMTP {
+		config, err := SMTPConfigFromEnv()
+		if err != nil {
+			return nil, fmt.Errorf("error configuring SMTP: %w", err)
+		}
+		smtpSender := NewSMTPSender(config, ec.UserInfo)
+		smtpSender.Cc = notifications.Recipients
+		smtpSender.Thread = thread
+		return smtpSender, nil
+	}
+
+	gmailSender := NewEmailSender(ec.Services.GmailService, ec.UserInfo)
+	if notifications.GmailLabel != "" {
+		gmailSender.Label = notifications.GmailLabel
+	}
+	gmailSender.Cc = notifications.Recipients
+	gmailSender.Thread = thread
+	gmailSender.CanModify = auth.HasScope(ec.Services.Scope, auth.GmailModifyScope)
+	return gmailSender, nil
+}
+
+// sendReminders delivers the documents that crossed a reminder threshold
+// since the last run.
+func (ec *ExpiryCheck) sendReminders(notifiers []Notifier, result *types.SheetResult) error {
+	if !ec.Spreadsheet.Notifications.Reminders {
+		return nil
+	}
+
+	due, keys, err := ec.Reminders.ClaimReminders(ec.Spreadsheet, result.Documents)
+	if err != nil {
+		return fmt.Errorf("error checking reminders: %w", err)
+	}
+	if len(due) == 0 {
+		return nil
+	}
+	if err := notify(notifiers, func(n Notifier) error { return n.SendDocumentReminders(due) }); err != nil {
+		ec.Reminders.Release(keys)
+		return fmt.Errorf("error sending reminders: %w", err)
+	}
+	return nil
+}
+
+// sendWeeklySummary delivers the full summary once per week.
+func (ec *ExpiryCheck) sendWeeklySummary(notifiers []Notifier, result *types.SheetResult, now time.Time) error {
+	if !ec.Spreadsheet.Notifications.EmailSummary {
+		return nil
+	}
+
+	key, due, err := ec.Reminders.ClaimSummary(ec.Spreadsheet, now)
+	if err != nil {
+		return fmt.Errorf("error checking weekly summary: %w", err)
+	}
+	if !due {
+		return nil
+	}
+	if err := ec.sendSummary(notifiers, result); err != nil {
+		ec.Reminders.Release([]string{key})
+		return err
+	}
+	return nil
+}
+
+func (ec *ExpiryCheck) sendSummary(notifiers []Notifier, result *types.SheetResult) error {
+	if err := notify(notifiers, func(n Notifier) error { return n.SendDocumentSummary(result) }); err != nil {
+		return fmt.Errorf("error sending summary: %w", err)
+	}
+	return nil
+}
+
+// notify calls send for every notifier. A notification counts as delivered
+// when at least one notifier succeeds, so a broken channel is only logged
+// and does not cause the others to be repeated on the next run.
+func notify(notifiers []Notifier, send func(Notifier) error) error {
+	var errs []error
+	for _, notifier := range notifiers {
+		if err := send(notifier); err != nil {
+			errs = append(errs, fmt.Errorf("%T: %w", notifier, err))
+		}
+	}
+	if len(errs) == len(notifiers) {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		fmt.Printf("failed to notify: %v\n", err)
+	}
+	return nil
+}
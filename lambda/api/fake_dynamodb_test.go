@@ -0,0 +1,354 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"lambda/database"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+type dynamoItem = map[string]*dynamodb.AttributeValue
+
+// fakeKeySchema names the partition and optional sort key of a table or
+// index.
+type fakeKeySchema struct {
+	HashKey  string
+	RangeKey string
+}
+
+// fakeTable holds the items of one table in insertion order.
+type fakeTable struct {
+	Key     fakeKeySchema
+	Indexes map[string]fakeKeySchema
+	Items   []dynamoItem
+}
+
+// fakeDynamoDB is an in-memory stand-in for the tables of the stack. It
+// understands the key conditions, condition and update expressions the
+// database package uses; any other call panics through the nil embedded
+// interface.
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+
+	mu     sync.Mutex
+	tables map[string]*fakeTable
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{tables: map[string]*fakeTable{
+		database.TABLE_NAME: {
+			Key:     fakeKeySchema{HashKey: "ID"},
+			Indexes: map[string]fakeKeySchema{database.USER_ID_INDEX_NAME: {HashKey: "UserID", RangeKey: "CreatedAt"}},
+		},
+		database.SPREADSHEET_TABLE_NAME:      {Key: fakeKeySchema{HashKey: "user_id", RangeKey: "spreadsheet_id"}},
+		database.REMINDER_TABLE_NAME:         {Key: fakeKeySchema{HashKey: "reminder_key"}},
+		database.SESSION_TABLE_NAME:          {Key: fakeKeySchema{HashKey: "nonce"}},
+		database.RESULT_TABLE_NAME:           {Key: fakeKeySchema{HashKey: "user_id", RangeKey: "spreadsheet_id"}},
+		database.DOCUMENT_TABLE_NAME:         {Key: fakeKeySchema{HashKey: "sheet_key", RangeKey: "id"}},
+		database.DOCUMENT_HISTORY_TABLE_NAME: {Key: fakeKeySchema{HashKey: "document_key", RangeKey: "change_key"}},
+		database.FEED_TABLE_NAME: {
+			Key:     fakeKeySchema{HashKey: "user_id"},
+			Indexes: map[string]fakeKeySchema{database.FEED_TOKEN_INDEX_NAME: {HashKey: "feed_token"}},
+		},
+	}}
+}
+
+// newTestStore returns a store backed by a fresh fake, encrypting tokens
+// with a fixed local key.
+func newTestStore(t *testing.T) (*database.DynamoDBStore, *fakeDynamoDB) {
+	t.Helper()
+	keys, err := database.NewLocalKeyProvider(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatalf("NewLocalKeyProvider: %v", err)
+	}
+	fake := newFakeDynamoDB()
+	return &database.DynamoDBStore{DB: fake, Keys: keys}, fake
+}
+
+// Items returns a copy of the items stored in a table.
+func (f *fakeDynamoDB) Items(tableName string) []dynamoItem {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var items []dynamoItem
+	for _, item := range f.table(tableName).Items {
+		items = append(items, copyItem(item))
+	}
+	return items
+}
+
+func (f *fakeDynamoDB) table(name string) *fakeTable {
+	table, ok := f.tables[name]
+	if !ok {
+		panic(fmt.Sprintf("fakeDynamoDB: unknown table %s", name))
+	}
+	return table
+}
+
+// find returns the position of the item with the key of item, or -1.
+func (table *fakeTable) find(item dynamoItem) int {
+	for i, existing := range table.Items {
+		if sameAttr(existing[table.Key.HashKey], item[table.Key.HashKey]) &&
+			(table.Key.RangeKey == "" || sameAttr(existing[table.Key.RangeKey], item[table.Key.RangeKey])) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *fakeDynamoDB) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	table := f.table(*input.TableName)
+	if i := table.find(input.Key); i >= 0 {
+		return &dynamodb.GetItemOutput{Item: copyItem(table.Items[i])}, nil
+	}
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	table := f.table(*input.TableName)
+	i := table.find(input.Item)
+	var existing dynamoItem
+	if i >= 0 {
+		existing = table.Items[i]
+	}
+	if err := checkCondition(input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues, existing); err != nil {
+		return nil, err
+	}
+	if i >= 0 {
+		table.Items[i] = copyItem(input.Item)
+	} else {
+		table.Items = append(table.Items, copyItem(input.Item))
+	}
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	table := f.table(*input.TableName)
+	i := table.find(input.Key)
+	var existing dynamoItem
+	if i >= 0 {
+		existing = table.Items[i]
+	}
+	if err := checkCondition(input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues, existing); err != nil {
+		return nil, err
+	}
+	output := &dynamodb.DeleteItemOutput{}
+	if i >= 0 {
+		table.Items = append(table.Items[:i], table.Items[i+1:]...)
+		if aws.StringValue(input.ReturnValues) == dynamodb.ReturnValueAllOld {
+			output.Attributes = existing
+		}
+	}
+	return output, nil
+}
+
+// UpdateItem supports "SET a = :v, ..." followed by an optional
+// "REMOVE a, ..." clause.
+func (f *fakeDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	table := f.table(*input.TableName)
+	i := table.find(input.Key)
+	var existing dynamoItem
+	if i >= 0 {
+		existing = table.Items[i]
+	}
+	if err := checkCondition(input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues, existing); err != nil {
+		return nil, err
+	}
+
+	item := copyItem(input.Key)
+	if existing != nil {
+		item = copyItem(existing)
+	}
+	expression := aws.StringValue(input.UpdateExpression)
+	var remove string
+	if j := strings.Index(expression, " REMOVE "); j >= 0 {
+		expression, remove = expression[:j], expression[j+len(" REMOVE "):]
+	}
+	for _, assignment := range strings.Split(strings.TrimPrefix(expression, "SET "), ",") {
+		parts := strings.SplitN(assignment, "=", 2)
+		name := attrName(strings.TrimSpace(parts[0]), input.ExpressionAttributeNames)
+		item[name] = input.ExpressionAttributeValues[strings.TrimSpace(parts[1])]
+	}
+	if remove != "" {
+		for _, name := range strings.Split(remove, ",") {
+			delete(item, attrName(strings.TrimSpace(name), input.ExpressionAttributeNames))
+		}
+	}
+
+	if i >= 0 {
+		table.Items[i] = item
+	} else {
+		table.Items = append(table.Items, item)
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) Query(input *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	table := f.table(*input.TableName)
+	schema := table.Key
+	if input.IndexName != nil {
+		schema = table.Indexes[*input.IndexName]
+	}
+
+	// Key conditions are "hash = :value"
+	parts := strings.SplitN(aws.StringValue(input.KeyConditionExpression), "=", 2)
+	name := attrName(strings.TrimSpace(parts[0]), input.ExpressionAttributeNames)
+	value := input.ExpressionAttributeValues[strings.TrimSpace(parts[1])]
+
+	var items []dynamoItem
+	for _, item := range table.Items {
+		if sameAttr(item[name], value) {
+			items = append(items, copyItem(item))
+		}
+	}
+	if schema.RangeKey != "" {
+		sort.SliceStable(items, func(i, j int) bool {
+			return attrString(items[i][schema.RangeKey]) < attrString(items[j][schema.RangeKey])
+		})
+	}
+	if input.ScanIndexForward != nil && !*input.ScanIndexForward {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+	}
+	if input.Limit != nil && int64(len(items)) > *input.Limit {
+		items = items[:*input.Limit]
+	}
+	return &dynamodb.QueryOutput{Items: items, Count: aws.Int64(int64(len(items)))}, nil
+}
+
+func (f *fakeDynamoDB) QueryPages(input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool) error {
+	output, err := f.Query(input)
+	if err != nil {
+		return err
+	}
+	fn(output, true)
+	return nil
+}
+
+func (f *fakeDynamoDB) ScanPages(input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool) error {
+	items := f.Items(*input.TableName)
+	fn(&dynamodb.ScanOutput{Items: items, Count: aws.Int64(int64(len(items)))}, true)
+	return nil
+}
+
+func (f *fakeDynamoDB) BatchWriteItem(input *dynamodb.BatchWriteItemInput) (*dynamodb.BatchWriteItemOutput, error) {
+	for tableName, requests := range input.RequestItems {
+		for _, writeRequest := range requests {
+			var err error
+			if writeRequest.PutRequest != nil {
+				_, err = f.PutItem(&dynamodb.PutItemInput{TableName: aws.String(tableName), Item: writeRequest.PutRequest.Item})
+			} else {
+				_, err = f.DeleteItem(&dynamodb.DeleteItemInput{TableName: aws.String(tableName), Key: writeRequest.DeleteRequest.Key})
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+// checkCondition evaluates a condition expression made of
+// attribute_exists, attribute_not_exists and comparisons joined by AND.
+func checkCondition(expression *string, names map[string]*string, values dynamoItem, item dynamoItem) error {
+	if expression == nil {
+		return nil
+	}
+	for _, clause := range strings.Split(*expression, " AND ") {
+		clause = strings.TrimSpace(clause)
+		var ok bool
+		switch {
+		case strings.HasPrefix(clause, "attribute_exists("):
+			_, ok = item[attrName(strings.TrimSuffix(strings.TrimPrefix(clause, "attribute_exists("), ")"), names)]
+		case strings.HasPrefix(clause, "attribute_not_exists("):
+			_, exists := item[attrName(strings.TrimSuffix(strings.TrimPrefix(clause, "attribute_not_exists("), ")"), names)]
+			ok = !exists
+		default:
+			fields := strings.Fields(clause)
+			if len(fields) != 3 {
+				panic(fmt.Sprintf("fakeDynamoDB: unsupported condition %q", clause))
+			}
+			ok = compareAttr(item[attrName(fields[0], names)], fields[1], values[fields[2]])
+		}
+		if !ok {
+			return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+		}
+	}
+	return nil
+}
+
+func compareAttr(a *dynamodb.AttributeValue, operator string, b *dynamodb.AttributeValue) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	var cmp int
+	if a.N != nil && b.N != nil {
+		x, _ := strconv.ParseFloat(*a.N, 64)
+		y, _ := strconv.ParseFloat(*b.N, 64)
+		switch {
+		case x < y:
+			cmp = -1
+		case x > y:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(attrString(a), attrString(b))
+	}
+	switch operator {
+	case "=":
+		return cmp == 0
+	case "<":
+		return cmp < 0
+	case ">":
+		return cmp > 0
+	}
+	panic(fmt.Sprintf("fakeDynamoDB: unsupported operator %q", operator))
+}
+
+func attrName(name string, names map[string]*string) string {
+	if strings.HasPrefix(name, "#") {
+		return aws.StringValue(names[name])
+	}
+	return name
+}
+
+func attrString(value *dynamodb.AttributeValue) string {
+	if value == nil {
+		return ""
+	}
+	if value.S != nil {
+		return *value.S
+	}
+	return aws.StringValue(value.N)
+}
+
+func sameAttr(a, b *dynamodb.AttributeValue) bool {
+	return a != nil && b != nil && a.String() == b.String()
+}
+
+func copyItem(item dynamoItem) dynamoItem {
+	copied := make(dynamoItem, len(item))
+	for name, value := range item {
+		copied[name] = value
+	}
+	return copied
+}
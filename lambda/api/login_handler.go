@@ -9,16 +9,22 @@ import (
 	"golang.org/x/oauth2"
 	"lambda/api/auth"
 	"lambda/database"
+	"lambda/types"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type LoginHandler struct {
 	sessionStore *database.DynamoDBStore
+	authConfig   *auth.AuthConfig
 }
 
-func NewLoginHandler() *LoginHandler {
-	return &LoginHandler{}
+func NewLoginHandler(dbStore *database.DynamoDBStore, authConfig *auth.AuthConfig) *LoginHandler {
+	return &LoginHandler{
+		sessionStore: dbStore,
+		authConfig:   authConfig,
+	}
 }
 
 func (lh *LoginHandler) GetSpreedSheetAndRedirect(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
@@ -37,7 +43,6 @@ func (lh *LoginHandler) GetSpreedSheetAndRedirect(request events.APIGatewayProxy
 			Body:       "spreadsheet_id is missing",
 		}, nil
 	}
-	fmt.Printf("this is the id: %s\n", id)
 	b := make([]byte, 16)
 	if _, err := rand.Read(b); err != nil {
 		return events.APIGatewayProxyResponse{
@@ -48,18 +53,74 @@ func (lh *LoginHandler) GetSpreedSheetAndRedirect(request events.APIGatewayProxy
 	}
 
 	nonce := base64.URLEncoding.EncodeToString(b)
-	// This logs to CloudWatch
-	fmt.Printf("this is the nonce login Handler: %s\n", nonce)
+	settings := types.SheetSettings{
+		SpreadsheetID: id,
+		SheetNames:    sheetNamesFromRequest(request),
+		Range:         strings.TrimSpace(request.QueryStringParameters["range"]),
+		DateLayouts:   request.MultiValueQueryStringParameters["date_format"],
+		DayFirst:      request.QueryStringParameters["day_first"] == "true",
+		Timezone:      strings.TrimSpace(request.QueryStringParameters["timezone"]),
+		WarningDays:   parseDays(request.QueryStringParameters["warning_days"]),
+		WriteBack:     request.QueryStringParameters["write_back"] == "true",
+		ColorRows:     request.QueryStringParameters["color_rows"] == "true",
+	}
+	if _, err := NewDateParser(&settings); err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusBadRequest,
+			Headers:    corsHeaders,
+			Body:       err.Error(),
+		}, nil
+	}
+
+	recipientValues := request.MultiValueQueryStringParameters["recipients"]
+	if len(recipientValues) == 0 {
+		recipientValues = []string{request.QueryStringParameters["recipients"]}
+	}
+	recipients, invalid := parseAddresses(strings.Join(recipientValues, ","))
+	if len(invalid) > 0 {
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusBadRequest,
+			Headers:    corsHeaders,
+			Body:       invalidAddressesError(invalid).Error(),
+		}, nil
+	}
+
 	statePayload := compositeState{
 		Nonce:         nonce,
-		SpreadsheetID: id,
+		SheetSettings: settings,
+		Notifications: types.NotificationSettings{
+			GmailLabel:   strings.TrimSpace(request.QueryStringParameters["gmail_label"]),
+			EmailSummary: request.QueryStringParameters["email_summary"] != "false",
+			Reminders:    request.QueryStringParameters["reminders"] != "false",
+			ReminderDays: parseDays(request.QueryStringParameters["reminder_days"]),
+			Recipients:   recipients,
+		},
+		Calendar: types.CalendarSettings{
+			Enabled:      request.QueryStringParameters["calendar"] == "true",
+			CalendarID:   strings.TrimSpace(request.QueryStringParameters["calendar_id"]),
+			ReminderDays: parseDays(request.QueryStringParameters["calendar_reminder_days"]),
+		},
 	}
+	// Chat and webhook channels carry credentials, so they are configured
+	// through PUT /settings/channels once signed in rather than travelling
+	// through Google in the state
 	raw, _ := json.Marshal(statePayload)
-	fmt.Printf("this is the raw loginHandler: %s\n", raw)
 	state := base64.URLEncoding.EncodeToString(raw)
-	fmt.Printf("this is the state loginHandler: %s\n", state)
-	authConfig := auth.NewAuthConfig()
-	oauthConfig := authConfig.ToOAuth2Config()
+
+	// Record the sign in so the callback can verify and consume its state.
+	// The code is only exchanged together with the PKCE verifier kept here,
+	// so an intercepted code is useless.
+	codeVerifier := oauth2.GenerateVerifier()
+	if err := lh.sessionStore.CreateOAuthSession(newOAuthSession(nonce, state, codeVerifier, time.Now())); err != nil {
+		fmt.Printf("failed to store sign in session: %v\n", err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusInternalServerError,
+			Headers:    corsHeaders,
+			Body:       "failed to start sign in",
+		}, nil
+	}
+
+	oauthConfig := lh.authConfig.ToOAuth2Config()
 	if oauthConfig == nil {
 		return events.APIGatewayProxyResponse{
 			StatusCode: http.StatusInternalServerError,
@@ -67,11 +128,31 @@ func (lh *LoginHandler) GetSpreedSheetAndRedirect(request events.APIGatewayProxy
 			Body:       "Oauth configuration not initialized",
 		}, nil
 	}
-	authURL := oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	authURL := oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(codeVerifier))
 	corsHeaders["Location"] = authURL
+	corsHeaders["Set-Cookie"] = oauthStateCookie(nonce)
 	return events.APIGatewayProxyResponse{
 		StatusCode: http.StatusTemporaryRedirect,
 		Headers:    corsHeaders,
 		Body:       "",
 	}, nil
 }
+
+// Helper function to collect the requested tabs. Tabs may be given as repeated
+// "sheet" parameters or as a single comma separated list.
+func sheetNamesFromRequest(request events.APIGatewayProxyRequest) []string {
+	values := request.MultiValueQueryStringParameters["sheet"]
+	if len(values) == 0 && request.QueryStringParameters["sheet"] != "" {
+		values = []string{request.QueryStringParameters["sheet"]}
+	}
+
+	var names []string
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
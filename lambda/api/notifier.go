@@ -0,0 +1,231 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"lambda/types"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Notifier delivers the results of an expiry check over one channel.
+type Notifier interface {
+	// SendDocumentSummary delivers the full summary of a processed sheet.
+	SendDocumentSummary(result *types.SheetResult) error
+	// SendDocumentReminders delivers the documents that crossed a reminder
+	// threshold.
+	SendDocumentReminders(docs []*types.Document) error
+}
+
+// Webhook requests must not hold up the rest of the check. Webhook URLs are
+// user supplied, so requests may only reach public https endpoints: the
+// dialer refuses internal addresses, even when a public name resolves to one
+// or a webhook redirects to one.
+var notifierHTTPClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: refuseInternalAddress,
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if req.URL.Scheme != "https" {
+			return errors.New("webhook redirected to a URL that is not https")
+		}
+		if len(via) >= 3 {
+			return errors.New("webhook redirected too many times")
+		}
+		return nil
+	},
+}
+
+// Shared address space used by carrier grade NAT, which is not reachable from
+// the internet either.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// Most documents listed in a single chat message; the rest are counted.
+const maxChatDocuments = 25
+
+// NewChannelNotifier returns the notifier for a configured channel.
+func NewChannelNotifier(spreadsheetID string, channel types.NotificationChannel) (Notifier, error) {
+	if err := validateChannel(channel); err != nil {
+		return nil, err
+	}
+
+	switch channel.Type {
+	case types.ChannelSlack:
+		return NewSlackNotifier(channel.URL), nil
+	case types.ChannelTeams:
+		return NewTeamsNotifier(channel.URL), nil
+	default:
+		return NewWebhookNotifier(spreadsheetID, channel.URL, channel.Secret)
+	}
+}
+
+// validateChannel checks a channel before it is saved or used.
+func validateChannel(channel types.NotificationChannel) error {
+	switch channel.Type {
+	case types.ChannelSlack, types.ChannelTeams, types.ChannelWebhook:
+	default:
+		return fmt.Errorf("unknown notification channel %q", channel.Type)
+	}
+	if channel.Type == types.ChannelWebhook && channel.Secret == "" {
+		return errWebhookSecretMissing
+	}
+
+	// The URL is a credential, so it is left out of the errors
+	u, err := url.Parse(channel.URL)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("%s webhook URL must be an https URL", channel.Type)
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("%s webhook URL must not point to an internal address", channel.Type)
+		}
+		return nil
+	}
+	if !strings.Contains(host, ".") || host == "localhost" {
+		return fmt.Errorf("%s webhook URL must not point to an internal host", channel.Type)
+	}
+	for _, suffix := range []string{".localhost", ".local", ".internal"} {
+		if strings.HasSuffix(host, suffix) {
+			return fmt.Errorf("%s webhook URL must not point to an internal host", channel.Type)
+		}
+	}
+	return nil
+}
+
+// refuseInternalAddress is the dialer control of webhook requests, run for
+// every address a webhook's host resolves to.
+func refuseInternalAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("refusing to call webhook at internal address %s", host)
+	}
+	return nil
+}
+
+// isPublicIP reports whether an address can be reached from the internet,
+// rejecting loopback, private, link local (which includes the instance
+// metadata service), multicast and unspecified addresses.
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() &&
+		!ip.IsPrivate() &&
+		!ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() &&
+		!ip.IsUnspecified() &&
+		!sharedAddressSpace.Contains(ip)
+}
+
+// postJSON sends payload to a webhook and fails unless it answers with a
+// 2xx status.
+func postJSON(client *http.Client, webhookURL string, payload interface{}, headers map[string]string) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding webhook payload: %w", err)
+	}
+	return postBody(client, webhookURL, body, headers)
+}
+
+func postBody(client *http.Client, webhookURL string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// chatDocuments returns the documents sorted by expiry date, soonest first,
+// limited to what fits in a chat message, and how many were left out.
+func chatDocuments(documents []*types.Document) ([]*types.Document, int) {
+	docs := append([]*types.Document{}, documents...)
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i].ExpiryDate.Before(docs[j].ExpiryDate)
+	})
+	if len(docs) > maxChatDocuments {
+		return docs[:maxChatDocuments], len(docs) - maxChatDocuments
+	}
+	return docs, 0
+}
+
+// summaryHeadline describes a summary in one line, e.g.
+// "Document Summary: Passports (2 Expired, 5 Valid)".
+func summaryHeadline(result *types.SheetResult) string {
+	counts := map[string]int{}
+	for _, doc := range result.Documents {
+		counts[doc.Status]++
+	}
+	var parts []string
+	for _, status := range statusOrder {
+		if counts[status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+
+	headline := "Document Summary"
+	if result.Title != "" {
+		headline += ": " + result.Title
+	}
+	if len(parts) > 0 {
+		headline += " (" + strings.Join(parts, ", ") + ")"
+	}
+	return headline
+}
+
+// remindersHeadline describes a reminder in one line.
+func remindersHeadline(docs []*types.Document) string {
+	return fmt.Sprintf("Document Reminder: %d document(s) need attention", len(docs))
+}
+
+// expiryDescription describes when a document expires relative to today,
+// e.g. "expires 2025-03-01 (in 12 days)".
+func expiryDescription(doc *types.Document) string {
+	date := doc.ExpiryDate.Format("2006-01-02")
+	if doc.Status == types.StatusUnknown {
+		return fmt.Sprintf("expires %s", date)
+	}
+	switch {
+	case doc.DaysRemaining > 1:
+		return fmt.Sprintf("expires %s (in %d days)", date, doc.DaysRemaining)
+	case doc.DaysRemaining == 1:
+		return fmt.Sprintf("expires %s (tomorrow)", date)
+	case doc.DaysRemaining == 0:
+		return fmt.Sprintf("expires %s (today)", date)
+	case doc.DaysRemaining == -1:
+		return fmt.Sprintf("expired %s (yesterday)", date)
+	default:
+		return fmt.Sprintf("expired %s (%d days ago)", date, -doc.DaysRemaining)
+	}
+}
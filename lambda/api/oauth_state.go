@@ -0,0 +1,112 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"lambda/database"
+	"lambda/types"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// A sign in has to be completed within oauthSessionTTL of starting it.
+const oauthSessionTTL = 10 * time.Minute
+
+// oauthStateCookieName holds the nonce of the sign in started in this
+// browser, so a callback URL started elsewhere is rejected.
+const oauthStateCookieName = "docexpiry_oauth_state"
+
+var errInvalidState = errors.New("invalid or expired sign in state")
+
+// newOAuthSession returns the server side record of a sign in, tied to the
+// exact state parameter sent to Google and holding the PKCE verifier whose
+// challenge was sent with it.
+func newOAuthSession(nonce, state, codeVerifier string, now time.Time) *types.OAuthSession {
+	return &types.OAuthSession{
+		Nonce:        nonce,
+		StateHash:    stateHash(state),
+		CodeVerifier: codeVerifier,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(oauthSessionTTL).Unix(),
+	}
+}
+
+// verifyOAuthState checks that a callback belongs to a sign in this browser
+// started and consumes its session, so the state cannot be replayed. Any
+// change to the state, including the settings it carries, is rejected.
+func verifyOAuthState(dbStore *database.DynamoDBStore, request events.APIGatewayProxyRequest, state string, composite *compositeState, now time.Time) (*types.OAuthSession, error) {
+	cookieNonce := oauthStateFromCookie(request)
+	if cookieNonce == "" || subtle.ConstantTimeCompare([]byte(cookieNonce), []byte(composite.Nonce)) != 1 {
+		return nil, errInvalidState
+	}
+
+	session, err := dbStore.ConsumeOAuthSession(composite.Nonce, now)
+	if errors.Is(err, database.ErrSessionNotFound) {
+		return nil, errInvalidState
+	}
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(session.StateHash), []byte(stateHash(state))) != 1 {
+		return nil, errInvalidState
+	}
+	return session, nil
+}
+
+func stateHash(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return hex.EncodeToString(sum[:])
+}
+
+// Helper function to build the cookie binding a sign in to the browser. Google
+// redirects back with a top level GET, so SameSite=Lax still sends it.
+func oauthStateCookie(nonce string) string {
+	cookie := &http.Cookie{
+		Name:     oauthStateCookieName,
+		Value:    nonce,
+		Path:     "/",
+		MaxAge:   int(oauthSessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	return cookie.String()
+}
+
+// Helper function to build the cookie removing the state cookie once its sign
+// in was consumed.
+func clearOAuthStateCookie() string {
+	cookie := &http.Cookie{
+		Name:     oauthStateCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	return cookie.String()
+}
+
+func oauthStateFromCookie(request events.APIGatewayProxyRequest) string {
+	header := http.Header{}
+	for name, values := range request.MultiValueHeaders {
+		for _, value := range values {
+			header.Add(name, value)
+		}
+	}
+	if len(header.Values("Cookie")) == 0 {
+		for name, value := range request.Headers {
+			header.Add(name, value)
+		}
+	}
+	cookie, err := (&http.Request{Header: header}).Cookie(oauthStateCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestVerifyOAuthState(t *testing.T) {
+	started := time.Date(2025, 3, 1, 8, 0, 0, 0, time.UTC)
+	state := "encoded-state"
+	callback := func(cookie string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{Headers: map[string]string{"Cookie": cookie}}
+	}
+
+	tests := []struct {
+		name      string
+		request   events.APIGatewayProxyRequest
+		nonce     string // Nonce carried in the state
+		state     string
+		now       time.Time
+		replay    bool // Verify the same callback twice
+		wantValid bool
+	}{
+		{
+			name:      "valid",
+			request:   callback(oauthStateCookieName + "=nonce-1"),
+			nonce:     "nonce-1",
+			state:     state,
+			now:       started.Add(time.Minute),
+			wantValid: true,
+		},
+		{
+			name:      "cookie among others",
+			request:   events.APIGatewayProxyRequest{MultiValueHeaders: map[string][]string{"Cookie": {"theme=dark", oauthStateCookieName + "=nonce-1"}}},
+			nonce:     "nonce-1",
+			state:     state,
+			now:       started.Add(time.Minute),
+			wantValid: true,
+		},
+		{
+			name:    "no cookie",
+			request: callback(""),
+			nonce:   "nonce-1",
+			state:   state,
+			now:     started.Add(time.Minute),
+		},
+		{
+			name:    "cookie of another sign in",
+			request: callback(oauthStateCookieName + "=nonce-2"),
+			nonce:   "nonce-1",
+			state:   state,
+			now:     started.Add(time.Minute),
+		},
+		{
+			name:    "state changed on the way",
+			request: callback(oauthStateCookieName + "=nonce-1"),
+			nonce:   "nonce-1",
+			state:   state + "x",
+			now:     started.Add(time.Minute),
+		},
+		{
+			name:    "unknown nonce",
+			request: callback(oauthStateCookieName + "=nonce-3"),
+			nonce:   "nonce-3",
+			state:   state,
+			now:     started.Add(time.Minute),
+		},
+		{
+			name:    "replayed",
+			request: callback(oauthStateCookieName + "=nonce-1"),
+			nonce:   "nonce-1",
+			state:   state,
+			now:     started.Add(time.Minute),
+			replay:  true,
+		},
+		{
+			name:    "expired",
+			request: callback(oauthStateCookieName + "=nonce-1"),
+			nonce:   "nonce-1",
+			state:   state,
+			now:     started.Add(oauthSessionTTL),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbStore, _ := newTestStore(t)
+			for _, nonce := range []string{"nonce-1", "nonce-2"} {
+				if err := dbStore.CreateOAuthSession(newOAuthSession(nonce, state, "verifier-"+nonce, started)); err != nil {
+					t.Fatalf("CreateOAuthSession: %v", err)
+				}
+			}
+			composite := &compositeState{Nonce: tt.nonce}
+			if tt.replay {
+				if _, err := verifyOAuthState(dbStore, tt.request, tt.state, composite, tt.now); err != nil {
+					t.Fatalf("first callback: %v", err)
+				}
+			}
+
+			session, err := verifyOAuthState(dbStore, tt.request, tt.state, composite, tt.now)
+			if !tt.wantValid {
+				if !errors.Is(err, errInvalidState) {
+					t.Errorf("verifyOAuthState error = %v, want %v", err, errInvalidState)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyOAuthState: %v", err)
+			}
+			if session.CodeVerifier != "verifier-nonce-1" {
+				t.Errorf("code verifier = %q, want that of nonce-1", session.CodeVerifier)
+			}
+		})
+	}
+}
+
+func TestOAuthStateCookies(t *testing.T) {
+	header := http.Header{"Set-Cookie": {oauthStateCookie("nonce-1"), clearOAuthStateCookie()}}
+	cookies := (&http.Response{Header: header}).Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("parsed %d cookies, want 2", len(cookies))
+	}
+	set, cleared := cookies[0], cookies[1]
+	if set.Name != oauthStateCookieName || set.Value != "nonce-1" || set.MaxAge != int(oauthSessionTTL.Seconds()) {
+		t.Errorf("state cookie = %+v", set)
+	}
+	if cleared.Name != oauthStateCookieName || cleared.Value != "" || cleared.MaxAge >= 0 {
+		t.Errorf("cleared cookie = %+v, want it expired", cleared)
+	}
+	for _, cookie := range []string{oauthStateCookie("nonce-1"), clearOAuthStateCookie()} {
+		for _, attribute := range []string{"Path=/", "HttpOnly", "Secure", "SameSite=Lax"} {
+			if !strings.Contains(cookie, attribute) {
+				t.Errorf("cookie %q lacks %s", cookie, attribute)
+			}
+		}
+	}
+}
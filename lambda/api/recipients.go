@@ -0,0 +1,123 @@
+package api
+
+import (
+	"fmt"
+	"lambda/types"
+	"net/mail"
+	"sort"
+	"strings"
+)
+
+// reminderGroup is one reminder email: the documents that share the same
+// owners.
+type reminderGroup struct {
+	To        []string
+	Cc        []string
+	Documents []*types.Document
+}
+
+// groupReminders splits the due documents by owner. Each group goes to the
+// documents' owners with the spreadsheet owner and the distribution list in
+// CC; documents without an owner go to the spreadsheet owner.
+func groupReminders(owner string, distribution []string, docs []*types.Document) []*reminderGroup {
+	groups := map[string]*reminderGroup{}
+	var order []string
+	for _, doc := range docs {
+		to := mergeAddresses(doc.Recipients)
+		if len(to) == 0 {
+			to = []string{owner}
+		}
+		groupKey := strings.ToLower(strings.Join(to, ","))
+
+		group, ok := groups[groupKey]
+		if !ok {
+			group = &reminderGroup{
+				To: to,
+				Cc: excludeAddresses(mergeAddresses([]string{owner}, distribution), to),
+			}
+			groups[groupKey] = group
+			order = append(order, groupKey)
+		}
+		group.Documents = append(group.Documents, doc)
+	}
+
+	result := make([]*reminderGroup, 0, len(order))
+	for _, groupKey := range order {
+		result = append(result, groups[groupKey])
+	}
+	return result
+}
+
+// parseAddresses reads a list of email addresses separated by commas,
+// semicolons or new lines, as typed into a sheet cell or query parameter.
+// Entries that are not valid addresses are returned separately.
+func parseAddresses(value string) ([]string, []string) {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n' || r == '\r'
+	})
+
+	var addresses, invalid []string
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		address, err := mail.ParseAddress(field)
+		if err != nil {
+			invalid = append(invalid, field)
+			continue
+		}
+		addresses = append(addresses, address.Address)
+	}
+	return mergeAddresses(addresses), invalid
+}
+
+// mergeAddresses joins address lists, dropping duplicates regardless of case
+// and keeping the first spelling of each address.
+func mergeAddresses(lists ...[]string) []string {
+	seen := map[string]bool{}
+	var merged []string
+	for _, list := range lists {
+		for _, address := range list {
+			key := strings.ToLower(strings.TrimSpace(address))
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged = append(merged, strings.TrimSpace(address))
+		}
+	}
+	return merged
+}
+
+// excludeAddresses returns the addresses that are not in exclude.
+func excludeAddresses(addresses, exclude []string) []string {
+	excluded := map[string]bool{}
+	for _, address := range exclude {
+		excluded[strings.ToLower(address)] = true
+	}
+
+	var kept []string
+	for _, address := range addresses {
+		if !excluded[strings.ToLower(address)] {
+			kept = append(kept, address)
+		}
+	}
+	return kept
+}
+
+// formatAddressList renders addresses for a To or Cc header.
+func formatAddressList(addresses []string) string {
+	formatted := make([]string, len(addresses))
+	for i, address := range addresses {
+		formatted[i] = (&mail.Address{Address: address}).String()
+	}
+	return strings.Join(formatted, ", ")
+}
+
+// invalidAddressesError reports addresses that could not be parsed.
+func invalidAddressesError(invalid []string) error {
+	sorted := append([]string{}, invalid...)
+	sort.Strings(sorted)
+	return fmt.Errorf("invalid email address: %s", strings.Join(sorted, ", "))
+}
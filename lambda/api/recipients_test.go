@@ -0,0 +1,95 @@
+package api
+
+import (
+	"lambda/types"
+	"reflect"
+	"testing"
+)
+
+func TestGroupReminders(t *testing.T) {
+	ownerOf := func(name, owners string) *types.Document {
+		recipients, _ := parseAddresses(owners)
+		return &types.Document{DocumentName: name, Recipients: recipients}
+	}
+
+	type group struct {
+		To        []string
+		Cc        []string
+		Documents []string
+	}
+	tests := []struct {
+		name         string
+		distribution []string
+		docs         []*types.Document
+		want         []group
+	}{
+		{
+			name: "no owners go to the account owner",
+			docs: []*types.Document{ownerOf("Passport", ""), ownerOf("Visa", "")},
+			want: []group{{To: []string{"me@example.com"}, Documents: []string{"Passport", "Visa"}}},
+		},
+		{
+			name: "one group per row owner",
+			docs: []*types.Document{ownerOf("Passport", "ann@example.com"), ownerOf("Visa", "bob@example.com"), ownerOf("Lease", "ann@example.com")},
+			want: []group{
+				{To: []string{"ann@example.com"}, Cc: []string{"me@example.com"}, Documents: []string{"Passport", "Lease"}},
+				{To: []string{"bob@example.com"}, Cc: []string{"me@example.com"}, Documents: []string{"Visa"}},
+			},
+		},
+		{
+			name: "several owners in one cell",
+			docs: []*types.Document{ownerOf("Lease", "ann@example.com, Bob <bob@example.com>; ann@example.com")},
+			want: []group{{To: []string{"ann@example.com", "bob@example.com"}, Cc: []string{"me@example.com"}, Documents: []string{"Lease"}}},
+		},
+		{
+			name:         "owners matched regardless of case",
+			distribution: []string{"Team@example.com", "ANN@example.com", "team@EXAMPLE.com"},
+			docs:         []*types.Document{ownerOf("Passport", "Ann@Example.com"), ownerOf("Visa", "ann@example.com, ANN@example.com")},
+			want: []group{{
+				To:        []string{"Ann@Example.com"},
+				Cc:        []string{"me@example.com", "Team@example.com"},
+				Documents: []string{"Passport", "Visa"},
+			}},
+		},
+		{
+			name:         "account owner named in the row",
+			distribution: []string{"team@example.com"},
+			docs:         []*types.Document{ownerOf("Passport", "ME@example.com"), ownerOf("Visa", "")},
+			want:         []group{{To: []string{"ME@example.com"}, Cc: []string{"team@example.com"}, Documents: []string{"Passport", "Visa"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []group
+			for _, g := range groupReminders("me@example.com", tt.distribution, tt.docs) {
+				var names []string
+				for _, doc := range g.Documents {
+					names = append(names, doc.DocumentName)
+				}
+				got = append(got, group{To: g.To, Cc: g.Cc, Documents: names})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("groupReminders =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAddresses(t *testing.T) {
+	tests := []struct {
+		value       string
+		want        []string
+		wantInvalid []string
+	}{
+		{"", nil, nil},
+		{"ann@example.com", []string{"ann@example.com"}, nil},
+		{"Ann <ann@example.com>;\nbob@example.com, ANN@example.com", []string{"ann@example.com", "bob@example.com"}, nil},
+		{"ann@example.com, not an address", []string{"ann@example.com"}, []string{"not an address"}},
+	}
+	for _, tt := range tests {
+		got, invalid := parseAddresses(tt.value)
+		if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(invalid, tt.wantInvalid) {
+			t.Errorf("parseAddresses(%q) = %q, %q, want %q, %q", tt.value, got, invalid, tt.want, tt.wantInvalid)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"fmt"
+	"lambda/database"
+	"lambda/types"
+	"sort"
+	"time"
+)
+
+// Reminder thresholds used when a spreadsheet does not configure its own.
+var defaultReminderDays = []int{90, 30, 7, 1, 0}
+
+// How long sent reminders are remembered after the document's expiry date.
+const reminderRetention = 365 * 24 * time.Hour
+
+// ReminderTracker decides which notifications are due and records them in
+// DynamoDB so repeated runs do not send them again.
+type ReminderTracker struct {
+	databaseStore *database.DynamoDBStore
+}
+
+func NewReminderTracker(dbStore *database.DynamoDBStore) *ReminderTracker {
+	return &ReminderTracker{
+		databaseStore: dbStore,
+	}
+}
+
+// ClaimReminders returns the documents that crossed a reminder threshold
+// since they were last reminded, together with the keys recorded for them.
+// Keys include the expiry date, so renewing a document starts its reminders
+// over.
+func (rt *ReminderTracker) ClaimReminders(spreadsheet *types.Spreadsheet, docs []*types.Document) ([]*types.Document, []string, error) {
+	days := reminderDays(spreadsheet.Notifications.ReminderDays)
+
+	var due []*types.Document
+	var keys []string
+	for _, doc := range docs {
+		threshold, ok := reminderThreshold(doc, days)
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%s#%s#%s#%s#%d",
+			spreadsheet.UserID,
+			spreadsheet.SpreadsheetID,
+			doc.ID,
+			doc.ExpiryDate.Format("2006-01-02"),
+			threshold)
+		claimed, err := rt.databaseStore.RecordReminder(key, doc.ExpiryDate.Add(reminderRetention))
+		if err != nil {
+			rt.Release(keys)
+			return nil, nil, err
+		}
+		if claimed {
+			due = append(due, doc)
+			keys = append(keys, key)
+		}
+	}
+	return due, keys, nil
+}
+
+// ClaimSummary reports whether the weekly summary of a spreadsheet is due,
+// recording it as sent for the current ISO week.
+func (rt *ReminderTracker) ClaimSummary(spreadsheet *types.Spreadsheet, now time.Time) (string, bool, error) {
+	year, week := now.ISOWeek()
+	key := fmt.Sprintf("%s#%s#summary#%d-W%02d", spreadsheet.UserID, spreadsheet.SpreadsheetID, year, week)
+	claimed, err := rt.databaseStore.RecordReminder(key, now.Add(reminderRetention))
+	return key, claimed, err
+}
+
+// Release forgets claimed notifications that could not be delivered so the
+// next run tries again.
+func (rt *ReminderTracker) Release(keys []string) {
+	for _, key := range keys {
+		if err := rt.databaseStore.DeleteReminder(key); err != nil {
+			fmt.Printf("failed to release reminder %s: %v\n", key, err)
+		}
+	}
+}
+
+// reminderThreshold returns the narrowest threshold a document has reached.
+// Expired documents count as reaching the expiry day so a missed run still
+// produces a final reminder.
+func reminderThreshold(doc *types.Document, days []int) (int, bool) {
+	if doc.Status == types.StatusUnknown {
+		return 0, false
+	}
+	remaining := doc.DaysRemaining
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	threshold, ok := 0, false
+	for _, day := range days {
+		if remaining <= day {
+			threshold, ok = day, true
+		}
+	}
+	return threshold, ok
+}
+
+// reminderDays returns the configured thresholds sorted from the widest to
+// the narrowest, falling back to the defaults.
+func reminderDays(configured []int) []int {
+	var days []int
+	for _, day := range configured {
+		if day >= 0 {
+			days = append(days, day)
+		}
+	}
+	if len(days) == 0 {
+		days = append(days, defaultReminderDays...)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(days)))
+	return days
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"errors"
+	"lambda/database"
+	"lambda/types"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReminderThreshold(t *testing.T) {
+	days := reminderDays(nil)
+	tests := []struct {
+		name      string
+		status    string
+		remaining int
+		want      int
+		wantOK    bool
+	}{
+		{name: "before the widest threshold", status: types.StatusValid, remaining: 91},
+		{name: "on the widest threshold", status: types.StatusValid, remaining: 90, want: 90, wantOK: true},
+		{name: "inside the widest threshold", status: types.StatusValid, remaining: 31, want: 90, wantOK: true},
+		{name: "on a narrower threshold", status: types.StatusExpiringSoon, remaining: 30, want: 30, wantOK: true},
+		{name: "a day past a threshold", status: types.StatusExpiringSoon, remaining: 6, want: 7, wantOK: true},
+		{name: "on the last day", status: types.StatusExpiringSoon, remaining: 1, want: 1, wantOK: true},
+		{name: "expiry day", status: types.StatusExpired, remaining: 0, want: 0, wantOK: true},
+		{name: "expired", status: types.StatusExpired, remaining: -12, want: 0, wantOK: true},
+		{name: "unknown status", status: types.StatusUnknown, remaining: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &types.Document{Status: tt.status, DaysRemaining: tt.remaining}
+			got, ok := reminderThreshold(doc, days)
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("reminderThreshold(%d days) = %d, %v, want %d, %v", tt.remaining, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestReminderDays(t *testing.T) {
+	tests := []struct {
+		configured []int
+		want       []int
+	}{
+		{nil, []int{90, 30, 7, 1, 0}},
+		{[]int{7, 30, 0}, []int{30, 7, 0}},
+		{[]int{-1, 14}, []int{14}},
+		{[]int{-1}, []int{90, 30, 7, 1, 0}},
+	}
+	for _, tt := range tests {
+		if got := reminderDays(tt.configured); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("reminderDays(%v) = %v, want %v", tt.configured, got, tt.want)
+		}
+	}
+}
+
+func TestClaimReminders(t *testing.T) {
+	dbStore, _ := newTestStore(t)
+	tracker := NewReminderTracker(dbStore)
+	spreadsheet := &types.Spreadsheet{UserID: "user-1", SheetSettings: types.SheetSettings{SpreadsheetID: "sheet-1"}}
+	passport := &types.Document{ID: "passport", ExpiryDate: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), Status: types.StatusExpiringSoon, DaysRemaining: 25}
+	visa := &types.Document{ID: "visa", ExpiryDate: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC), Status: types.StatusValid, DaysRemaining: 390}
+
+	due, keys, err := tracker.ClaimReminders(spreadsheet, []*types.Document{passport, visa})
+	if err != nil {
+		t.Fatalf("ClaimReminders: %v", err)
+	}
+	if len(due) != 1 || due[0] != passport || len(keys) != 1 {
+		t.Fatalf("due = %v, keys = %v, want the passport only", due, keys)
+	}
+	if want := "user-1#sheet-1#passport#2025-04-01#30"; keys[0] != want {
+		t.Errorf("key = %q, want %q", keys[0], want)
+	}
+
+	// The same threshold is not reminded twice
+	if due, _, err := tracker.ClaimReminders(spreadsheet, []*types.Document{passport}); err != nil || len(due) != 0 {
+		t.Errorf("second claim = %v, %v, want nothing due", due, err)
+	}
+
+	// Crossing the next threshold is
+	passport.DaysRemaining = 7
+	if due, _, err := tracker.ClaimReminders(spreadsheet, []*types.Document{passport}); err != nil || len(due) != 1 {
+		t.Errorf("claim at 7 days = %v, %v, want the passport", due, err)
+	}
+
+	// Renewing the document starts its reminders over
+	passport.ExpiryDate = time.Date(2025, 4, 8, 0, 0, 0, 0, time.UTC)
+	due, keys, err = tracker.ClaimReminders(spreadsheet, []*types.Document{passport})
+	if err != nil || len(due) != 1 {
+		t.Fatalf("claim after the expiry date moved = %v, %v, want the passport", due, err)
+	}
+	if !strings.Contains(keys[0], "#2025-04-08#") {
+		t.Errorf("key = %q, want the new expiry date", keys[0])
+	}
+
+	// Released reminders are claimed again
+	tracker.Release(keys)
+	if due, _, err := tracker.ClaimReminders(spreadsheet, []*types.Document{passport}); err != nil || len(due) != 1 {
+		t.Errorf("claim after release = %v, %v, want the passport", due, err)
+	}
+}
+
+// failingNotifier fails every notification.
+type failingNotifier struct {
+	calls int
+}
+
+func (n *failingNotifier) SendDocumentSummary(*types.SheetResult) error {
+	n.calls++
+	return errors.New("mailbox unavailable")
+}
+
+func (n *failingNotifier) SendDocumentReminders([]*types.Document) error {
+	n.calls++
+	return errors.New("mailbox unavailable")
+}
+
+func TestSendRemindersReleasesOnFailure(t *testing.T) {
+	dbStore, fake := newTestStore(t)
+	spreadsheet := &types.Spreadsheet{
+		UserID:        "user-1",
+		SheetSettings: types.SheetSettings{SpreadsheetID: "sheet-1"},
+		Notifications: types.NotificationSettings{Reminders: true, EmailSummary: true},
+	}
+	check := &ExpiryCheck{Spreadsheet: spreadsheet, Reminders: NewReminderTracker(dbStore)}
+	result := &types.SheetResult{Documents: []*types.Document{
+		{ID: "passport", ExpiryDate: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), Status: types.StatusExpiringSoon, DaysRemaining: 7},
+	}}
+	notifier := &failingNotifier{}
+
+	if err := check.sendReminders([]Notifier{notifier}, result); err == nil {
+		t.Fatal("sendReminders succeeded although every notifier failed")
+	}
+	if err := check.sendWeeklySummary([]Notifier{notifier}, result, time.Date(2025, 3, 25, 8, 0, 0, 0, time.UTC)); err == nil {
+		t.Fatal("sendWeeklySummary succeeded although every notifier failed")
+	}
+	if notifier.calls != 2 {
+		t.Errorf("notifier called %d times, want 2", notifier.calls)
+	}
+	if items := fake.Items(database.REMINDER_TABLE_NAME); len(items) != 0 {
+		t.Errorf("%d reminders still recorded after the failures", len(items))
+	}
+
+	// The next run tries again
+	if err := check.sendReminders([]Notifier{notifier}, result); err == nil || notifier.calls != 3 {
+		t.Errorf("retry: error = %v, calls = %d, want the reminder sent again", err, notifier.calls)
+	}
+}
+
+func TestClaimSummaryPerISOWeek(t *testing.T) {
+	dbStore, _ := newTestStore(t)
+	tracker := NewReminderTracker(dbStore)
+	spreadsheet := &types.Spreadsheet{UserID: "user-1", SheetSettings: types.SheetSettings{SpreadsheetID: "sheet-1"}}
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		wantKey string
+		wantDue bool
+	}{
+		{name: "last week of 2024", now: time.Date(2024, 12, 29, 9, 0, 0, 0, time.UTC), wantKey: "2024-W52", wantDue: true},
+		{name: "Monday in December starts 2025-W01", now: time.Date(2024, 12, 30, 9, 0, 0, 0, time.UTC), wantKey: "2025-W01", wantDue: true},
+		{name: "same ISO week in the new year", now: time.Date(2025, 1, 5, 23, 0, 0, 0, time.UTC), wantKey: "2025-W01"},
+		{name: "next week", now: time.Date(2025, 1, 6, 0, 0, 0, 0, time.UTC), wantKey: "2025-W02", wantDue: true},
+		{name: "first week of 2026 starts in 2025", now: time.Date(2025, 12, 29, 9, 0, 0, 0, time.UTC), wantKey: "2026-W01", wantDue: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, due, err := tracker.ClaimSummary(spreadsheet, tt.now)
+			if err != nil {
+				t.Fatalf("ClaimSummary: %v", err)
+			}
+			if want := "user-1#sheet-1#summary#" + tt.wantKey; key != want {
+				t.Errorf("key = %q, want %q", key, want)
+			}
+			if due != tt.wantDue {
+				t.Errorf("due = %v, want %v", due, tt.wantDue)
+			}
+		})
+	}
+}
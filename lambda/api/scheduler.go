@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"lambda/api/auth"
+	"lambda/database"
+	"lambda/types"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"golang.org/x/oauth2"
+)
+
+// Scheduler runs the expiry check for every stored user. It is invoked by an
+// EventBridge schedule rather than through API Gateway.
+type Scheduler struct {
+	databaseStore *database.DynamoDBStore
+	authConfig    *auth.AuthConfig
+}
+
+func NewScheduler(dbStore *database.DynamoDBStore, authConfig *auth.AuthConfig) *Scheduler {
+	return &Scheduler{
+		databaseStore: dbStore,
+		authConfig:    authConfig,
+	}
+}
+
+// Run checks the registered spreadsheets of every user. A failing user is
+// logged and skipped so one broken sheet does not block everyone else's
+// notifications, and so EventBridge does not retry users already notified.
+func (s *Scheduler) Run(ctx context.Context, event events.CloudWatchEvent) error {
+	fmt.Printf("scheduled expiry check started: %s\n", event.ID)
+
+	tokens, err := s.databaseStore.LatestTokens()
+	if err != nil {
+		return err
+	}
+
+	checked, failed := 0, 0
+	for _, token := range tokens {
+		spreadsheets, err := s.databaseStore.ListSpreadsheets(token.UserID)
+		if err != nil {
+			fmt.Printf("listing spreadsheets failed for user %s: %v\n", token.UserID, err)
+			failed++
+			continue
+		}
+		if len(spreadsheets) == 0 {
+			continue
+		}
+
+		n, err := s.checkUser(ctx, token, spreadsheets)
+		checked += n
+		if err != nil {
+			fmt.Printf("scheduled check failed for user %s: %v\n", token.UserID, err)
+			failed += len(spreadsheets) - n
+		}
+	}
+
+	fmt.Printf("scheduled expiry check finished: %d checked, %d failed\n", checked, failed)
+	return nil
+}
+
+// checkUser refreshes the user's token when needed and checks each of their
+// spreadsheets, returning how many were checked successfully.
+func (s *Scheduler) checkUser(ctx context.Context, token *types.Token, spreadsheets []*types.Spreadsheet) (int, error) {
+	googleServices, err := storedUserServices(ctx, s.databaseStore, s.authConfig, token)
+	if err != nil {
+		return 0, err
+	}
+
+	userInfo := &types.UserInfo{
+		ID:    token.UserID,
+		Email: token.Email,
+	}
+	checked := 0
+	var errs []error
+	for _, spreadsheet := range spreadsheets {
+		check := NewExpiryCheck(googleServices, userInfo, spreadsheet, s.databaseStore)
+		check.Reminders = NewReminderTracker(s.databaseStore)
+		check.SyncCalendar = true
+		if _, err := check.Run(time.Now()); err != nil {
+			errs = append(errs, fmt.Errorf("spreadsheet %s: %w", spreadsheet.SpreadsheetID, err))
+			continue
+		}
+		checked++
+	}
+	return checked, errors.Join(errs...)
+}
+
+// storedUserServices creates the Google services of a stored token,
+// refreshing the token when needed and saving the refreshed one on the same
+// record so the next run does not refresh again.
+func storedUserServices(ctx context.Context, dbStore *database.DynamoDBStore, authConfig *auth.AuthConfig, token *types.Token) (*GoogleServices, error) {
+	oauthToken, err := refreshOAuthToken(ctx, authConfig, token)
+	if err != nil {
+		return nil, fmt.Errorf("token refresh failed: %w", err)
+	}
+
+	if oauthToken.AccessToken != token.AccessToken {
+		token.AccessToken = oauthToken.AccessToken
+		token.TokenType = oauthToken.TokenType
+		token.RefreshToken = oauthToken.RefreshToken
+		token.Expiry = oauthToken.Expiry
+		token.Scope = auth.GrantedScope(oauthToken)
+		if err := dbStore.UpdateToken(token); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewGoogleServices(oauthToken)
+}
+
+// refreshOAuthToken returns a valid access token for the stored token,
+// refreshing it when it has expired.
+func refreshOAuthToken(ctx context.Context, authConfig *auth.AuthConfig, token *types.Token) (*oauth2.Token, error) {
+	oauthCfg := authConfig.ToOAuth2Config()
+	oauthToken, err := oauthCfg.TokenSource(ctx, &oauth2.Token{
+		AccessToken:  token.AccessToken,
+		TokenType:    token.TokenType,
+		RefreshToken: token.RefreshToken,
+		Expiry:       token.Expiry,
+	}).Token()
+	if err != nil {
+		return nil, err
+	}
+	// Only refresh responses report the granted scopes; otherwise carry
+	// over the ones stored at sign in
+	if auth.GrantedScope(oauthToken) == "" {
+		oauthToken = oauthToken.WithExtra(map[string]interface{}{"scope": token.Scope})
+	}
+	return oauthToken, nil
+}
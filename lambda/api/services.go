@@ -2,23 +2,38 @@ package api
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/gmail/v1"
 	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
+	"lambda/api/auth"
 	"lambda/types"
 	"net/http"
+	"net/mail"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// Defaults for the notification emails.
+const (
+	defaultFromName   = "DocExpiry"
+	defaultGmailLabel = "DocExpiry"
+)
+
 type GoogleServices struct {
-	Client        *http.Client
-	SheetsService *sheets.Service
-	GmailService  *gmail.Service
+	Client          *http.Client
+	SheetsService   *sheets.Service
+	GmailService    *gmail.Service
+	CalendarService *calendar.Service
+	Scope           string // Space separated scopes granted with the token
 }
 
 type SheetProcessor struct {
@@ -28,6 +43,17 @@ type SheetProcessor struct {
 type EmailSender struct {
 	Service  *gmail.Service
 	UserInfo *types.UserInfo
+	FromName string             // Display name shown in the From header
+	Label    string             // Gmail label applied to every message sent
+	Cc       []string           // Distribution list copied on every email
+	Thread   *types.EmailThread // Conversation summaries are kept in, if any
+
+	// CanModify is set when the user granted gmail.modify, which labelling
+	// a sent message and reading back its Message-ID need. Without it the
+	// message is sent unlabelled and replies are threaded by thread ID only.
+	CanModify bool
+
+	labelID string
 }
 
 func NewGoogleServices(token *oauth2.Token) (*GoogleServices, error) {
@@ -46,10 +72,18 @@ func NewGoogleServices(token *oauth2.Token) (*GoogleServices, error) {
 		return nil, fmt.Errorf("failed to create gmail service: %v", err)
 	}
 
+	// Initialize Calendar service
+	calendarService, err := calendar.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create calendar service: %v", err)
+	}
+
 	return &GoogleServices{
-		Client:        client,
-		SheetsService: sheetsService,
-		GmailService:  gmailService,
+		Client:          client,
+		SheetsService:   sheetsService,
+		GmailService:    gmailService,
+		CalendarService: calendarService,
+		Scope:           auth.GrantedScope(token),
 	}, nil
 }
 
@@ -59,102 +93,332 @@ func NewSheetProcessor(service *sheets.Service) *SheetProcessor {
 	}
 }
 
-func (sp *SheetProcessor) ProcessSheetData(spreadsheetID, readRange string) ([]*types.Document, error) {
-	// Fetch data from spreadsheet
-	resp, err := sp.Service.Spreadsheets.Values.Get(spreadsheetID, readRange).Do()
+func (sp *SheetProcessor) ProcessSheetData(settings *types.SheetSettings) (*types.SheetResult, error) {
+	dateParser, err := NewDateParser(settings)
 	if err != nil {
-		return nil, fmt.Errorf("unable to retrieve data from sheet: %v", err)
+		return nil, err
 	}
 
-	if len(resp.Values) == 0 {
-		return nil, fmt.Errorf("no data found")
+	title, tabs, err := sp.resolveTabs(settings.SpreadsheetID, settings.SheetNames)
+	if err != nil {
+		return nil, err
 	}
 
+	result := &types.SheetResult{
+		Title:     title,
+		Documents: []*types.Document{},
+		Issues:    []types.RowIssue{},
+	}
+	for _, tab := range tabs {
+		// Fetch data from spreadsheet
+		values, err := sp.readTab(settings.SpreadsheetID, tab, settings.Range)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := parseDocuments(values, dateParser, result); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// parseDocuments turns the rows of a single tab into documents, recording an
+// issue for every row that has to be skipped.
+func parseDocuments(tab *sheetTab, dateParser *DateParser, result *types.SheetResult) error {
+	if len(tab.Rows) == 0 {
+		return fmt.Errorf("no data found in sheet %q", tab.Title)
+	}
+
+	// The first row holds the headers; columns are matched by name so the
+	// sheet can be reordered or carry extra columns.
+	columns, missing := mapColumns(tab.Rows[0])
+	if len(missing) > 0 {
+		return &MissingColumnsError{Sheet: tab.Title, Columns: missing}
+	}
+
+	headers := make([]string, len(tab.Rows[0]))
+	for i, cell := range tab.Rows[0] {
+		headers[i] = strings.TrimSpace(fmt.Sprintf("%v", cell))
+	}
+	result.Tabs = append(result.Tabs, &types.SheetTab{
+		Title:       tab.Title,
+		SheetID:     tab.SheetID,
+		HeaderRow:   tab.FirstRow,
+		FirstColumn: tab.FirstColumn,
+		ColumnCount: tab.ColumnCount,
+		Headers:     headers,
+	})
+
 	// Process the sheet data into documents
-	var docs []*types.Document
-	for _, row := range resp.Values {
-		if len(row) < 5 {
-			fmt.Printf("Skipping row with insufficient columns: %v\n", row)
-			continue
+	seen := map[string]int{}
+	for i, row := range tab.Rows[1:] {
+		rowNumber := tab.FirstRow + i + 1
+		addIssue := func(key, reason string) {
+			issue := types.RowIssue{
+				Sheet:  tab.Title,
+				Row:    rowNumber,
+				Column: columnLabel(key),
+				Value:  columns.value(row, key),
+				Reason: reason,
+			}
+			fmt.Printf("Skipping %s row %d: %s\n", issue.Sheet, issue.Row, issue.Reason)
+			result.Issues = append(result.Issues, issue)
 		}
 
-		documentName := fmt.Sprintf("%v", row[0])
-		fmt.Printf("Attempting to parse dates for document: %s\n", documentName)
+		if isBlankRow(row) {
+			continue
+		}
 
-		issueDate, err1 := time.Parse("2006-01-02", fmt.Sprintf("%v", row[1]))
-		if err1 != nil {
-			fmt.Printf("Error parsing issue date '%v': %v\n", row[1], err1)
+		documentName := columns.value(row, columnName)
+		if documentName == "" {
+			addIssue(columnName, "missing document name")
+			continue
 		}
 
-		expiryDate, err2 := time.Parse("2006-01-02", fmt.Sprintf("%v", row[2]))
-		if err2 != nil {
-			fmt.Printf("Error parsing expiry date '%v': %v\n", row[2], err2)
+		var issueDate time.Time
+		var err error
+		if columns.value(row, columnIssueDate) != "" {
+			issueDate, err = dateParser.Parse(columns.raw(row, columnIssueDate))
+			if err != nil {
+				addIssue(columnIssueDate, err.Error())
+				continue
+			}
 		}
 
-		durationDaysStr := fmt.Sprintf("%v", row[3])
-		durationDays, err3 := strconv.Atoi(durationDaysStr)
-		if err3 != nil {
-			fmt.Printf("Error parsing duration '%v': %v\n", row[3], err3)
+		if columns.value(row, columnExpiryDate) == "" {
+			addIssue(columnExpiryDate, "missing expiry date")
+			continue
+		}
+		expiryDate, err := dateParser.Parse(columns.raw(row, columnExpiryDate))
+		if err != nil {
+			addIssue(columnExpiryDate, err.Error())
 			continue
 		}
-		durationValue := time.Duration(durationDays) * 24 * time.Hour
 
-		status := fmt.Sprintf("%v", row[4])
+		// Fall back to the gap between the two dates when no duration is given
+		var durationValue time.Duration
+		if durationDaysStr := columns.value(row, columnDuration); durationDaysStr != "" {
+			durationDays, err := strconv.ParseFloat(durationDaysStr, 64)
+			if err != nil {
+				addIssue(columnDuration, "duration is not a number of days")
+				continue
+			}
+			durationValue = time.Duration(durationDays * float64(24*time.Hour))
+		} else if !issueDate.IsZero() {
+			durationValue = expiryDate.Sub(issueDate)
+		}
 
-		fmt.Printf("document name: %v\n", documentName)
-		fmt.Printf("status: %v\n", status)
-		fmt.Printf("issue date: %v\n", issueDate)
-		fmt.Printf("expiry date: %v\n", expiryDate)
+		status := columns.value(row, columnStatus)
 
-		if err1 != nil || err2 != nil {
-			continue
+		// Reminders go to the people named in the row; bad addresses are
+		// reported but do not stop the document from being tracked
+		var recipients []string
+		for _, key := range []string{columnOwner, columnNotify} {
+			addresses, invalid := parseAddresses(columns.value(row, key))
+			if len(invalid) > 0 {
+				issue := types.RowIssue{
+					Sheet:  tab.Title,
+					Row:    rowNumber,
+					Column: columnLabel(key),
+					Value:  columns.value(row, key),
+					Reason: invalidAddressesError(invalid).Error(),
+				}
+				fmt.Printf("Ignoring addresses in %s row %d: %s\n", issue.Sheet, issue.Row, issue.Reason)
+				result.Issues = append(result.Issues, issue)
+			}
+			recipients = mergeAddresses(recipients, addresses)
 		}
 
-		// Create a doc and append to the slice
+		// Create a doc and append to the result
 		doc := types.NewDoc(documentName, issueDate, expiryDate, durationValue, status)
-		docs = append(docs, doc)
+		doc.Sheet = tab.Title
+		doc.Row = rowNumber
+		doc.Recipients = recipients
+		seen[normalizeHeader(documentName)]++
+		doc.ID = documentID(tab.Title, documentName, seen[normalizeHeader(documentName)])
+		result.Documents = append(result.Documents, doc)
 	}
 
-	return docs, nil
+	return nil
+}
+
+// documentID derives a stable identity from the tab and document name, so a
+// document keeps its ID when rows are sorted or inserted above it. Repeated
+// names within a tab are told apart by their order of appearance.
+func documentID(sheet, documentName string, occurrence int) string {
+	key := fmt.Sprintf("%s\x00%s\x00%d", strings.ToLower(sheet), normalizeHeader(documentName), occurrence)
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
+
+// isBlankRow reports whether every cell in the row is empty.
+func isBlankRow(row []interface{}) bool {
+	for _, cell := range row {
+		if cell != nil && strings.TrimSpace(fmt.Sprintf("%v", cell)) != "" {
+			return false
+		}
+	}
+	return true
 }
 
 func NewEmailSender(service *gmail.Service, userInfo *types.UserInfo) *EmailSender {
+	fromName := os.Getenv("EMAIL_FROM_NAME")
+	if fromName == "" {
+		fromName = defaultFromName
+	}
 	return &EmailSender{
 		Service:  service,
 		UserInfo: userInfo,
+		FromName: fromName,
+		Label:    defaultGmailLabel,
 	}
 }
 
-func (es *EmailSender) SendDocumentSummary(docs []*types.Document) error {
+// SendDocumentSummary emails the summary of a processed sheet to the owner,
+// copying the distribution list. When Thread is set the summary is sent as a
+// reply in that conversation and Thread is updated with the message just
+// sent.
+func (es *EmailSender) SendDocumentSummary(result *types.SheetResult) error {
 	// Build email content
-	var bodyContent strings.Builder
-	bodyContent.WriteString("Document Summary\n")
-	bodyContent.WriteString("===============\n\n")
+	subject, email, err := summaryEmail(result)
+	if err != nil {
+		return err
+	}
+	to := []string{es.UserInfo.Email}
+	return es.send(subject, email, to, excludeAddresses(es.Cc, to), es.Thread)
+}
 
-	for _, doc := range docs {
-		bodyContent.WriteString(fmt.Sprintf("Document: %s\nIssue Date: %s\nExpiry Date: %s\nStatus: %s\n\n",
-			doc.DocumentName,
-			doc.IssueDate.Format("2006-01-02"),
-			doc.ExpiryDate.Format("2006-01-02"),
-			doc.Status))
+// SendDocumentReminders emails the documents that crossed a reminder
+// threshold, one email per set of document owners so nobody sees reminders
+// for documents they are not responsible for.
+func (es *EmailSender) SendDocumentReminders(docs []*types.Document) error {
+	var errs []error
+	for _, group := range groupReminders(es.UserInfo.Email, es.Cc, docs) {
+		subject, email, err := reminderEmail(group.Documents)
+		if err != nil {
+			return err
+		}
+		if err := es.send(subject, email, group.To, group.Cc, nil); err != nil {
+			errs = append(errs, fmt.Errorf("error sending reminder email to %s: %w", strings.Join(group.To, ", "), err))
+		}
 	}
+	return errors.Join(errs...)
+}
 
+func (es *EmailSender) send(subject string, email *renderedEmail, to, cc []string, thread *types.EmailThread) error {
 	// Create the email
-	var emailBuilder strings.Builder
-	emailBuilder.WriteString("From: me\r\n")
-	emailBuilder.WriteString(fmt.Sprintf("To: %s\r\n", es.UserInfo.Email))
-	emailBuilder.WriteString("Subject: Document Summary\r\n")
-	emailBuilder.WriteString("MIME-Version: 1.0\r\n")
-	emailBuilder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
-	emailBuilder.WriteString(bodyContent.String())
+	headers := &emailHeaders{
+		From:    (&mail.Address{Name: es.FromName, Address: es.UserInfo.Email}).String(),
+		To:      to,
+		Cc:      cc,
+		Subject: subject,
+	}
+	if thread != nil {
+		headers.InReplyTo = thread.MessageID
+	}
+	raw, err := composeEmail(headers, email)
+	if err != nil {
+		return err
+	}
 
 	// Encode the email
-	emailRaw := base64.RawURLEncoding.EncodeToString([]byte(emailBuilder.String()))
+	message := &gmail.Message{
+		Raw: base64.RawURLEncoding.EncodeToString(raw),
+	}
+	if thread != nil {
+		message.ThreadId = thread.ThreadID
+	}
 
 	// Send email
-	_, err := es.Service.Users.Messages.Send("me", &gmail.Message{
-		Raw: emailRaw,
-	}).Do()
+	sent, err := es.Service.Users.Messages.Send("me", message).Do()
+	if err != nil {
+		return err
+	}
 
+	if !es.CanModify {
+		if thread != nil {
+			thread.ThreadID = sent.ThreadId
+			thread.MessageID = ""
+		}
+		return nil
+	}
+
+	if err := es.applyLabel(sent.Id); err != nil {
+		// The message went out; a missing label is not worth a resend
+		fmt.Printf("failed to label message %s: %v\n", sent.Id, err)
+	}
+
+	if thread != nil {
+		messageID, err := es.messageID(sent.Id)
+		if err != nil {
+			fmt.Printf("failed to read Message-ID of %s: %v\n", sent.Id, err)
+		}
+		thread.ThreadID = sent.ThreadId
+		thread.MessageID = messageID
+	}
+	return nil
+}
+
+// applyLabel adds the configured label to a message, creating the label the
+// first time it is used.
+func (es *EmailSender) applyLabel(messageID string) error {
+	if es.Label == "" {
+		return nil
+	}
+	if es.labelID == "" {
+		labelID, err := es.ensureLabel(es.Label)
+		if err != nil {
+			return err
+		}
+		es.labelID = labelID
+	}
+
+	_, err := es.Service.Users.Messages.Modify("me", messageID, &gmail.ModifyMessageRequest{
+		AddLabelIds: []string{es.labelID},
+	}).Do()
 	return err
 }
+
+// ensureLabel returns the ID of the user's label with the given name.
+func (es *EmailSender) ensureLabel(name string) (string, error) {
+	labels, err := es.Service.Users.Labels.List("me").Do()
+	if err != nil {
+		return "", fmt.Errorf("error listing labels: %w", err)
+	}
+	for _, label := range labels.Labels {
+		if strings.EqualFold(label.Name, name) {
+			return label.Id, nil
+		}
+	}
+
+	label, err := es.Service.Users.Labels.Create("me", &gmail.Label{
+		Name:                  name,
+		LabelListVisibility:   "labelShow",
+		MessageListVisibility: "show",
+	}).Do()
+	if err != nil {
+		return "", fmt.Errorf("error creating label: %w", err)
+	}
+	return label.Id, nil
+}
+
+// messageID returns the Message-ID header Gmail assigned to a sent message.
+func (es *EmailSender) messageID(id string) (string, error) {
+	message, err := es.Service.Users.Messages.Get("me", id).
+		Format("metadata").
+		MetadataHeaders("Message-ID").
+		Do()
+	if err != nil {
+		return "", err
+	}
+	if message.Payload != nil {
+		for _, header := range message.Payload.Headers {
+			if strings.EqualFold(header.Name, "Message-ID") {
+				return header.Value, nil
+			}
+		}
+	}
+	return "", nil
+}
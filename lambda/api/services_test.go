@@ -0,0 +1,96 @@
+package api
+
+import (
+	"errors"
+	"lambda/types"
+	"reflect"
+	"testing"
+)
+
+func TestParseDocuments(t *testing.T) {
+	dateParser, err := NewDateParser(&types.SheetSettings{})
+	if err != nil {
+		t.Fatalf("NewDateParser: %v", err)
+	}
+	// The range starts at row 3, so the header is row 3 and data starts at 4
+	tab := &sheetTab{
+		Title:    "Register",
+		FirstRow: 3,
+		Rows: [][]interface{}{
+			{"Document Name", "Issue Date", "Expiry Date", "Owner"},
+			{"Passport", "2020-03-04", "2030-03-04"},
+			{"Visa"},
+			{},
+			{"Licence", "", "soon"},
+			{" ", "", "2026-01-01"},
+			{"Insurance", "someday", "2026-01-01"},
+			{"Lease", "", "2026-06-30", "ops@example.com, not an address"},
+			{"Passport", "", "2031-01-01"},
+		},
+	}
+
+	result := &types.SheetResult{}
+	if err := parseDocuments(tab, dateParser, result); err != nil {
+		t.Fatalf("parseDocuments: %v", err)
+	}
+
+	wantIssues := []types.RowIssue{
+		{Sheet: "Register", Row: 5, Column: "Expiry Date", Reason: "missing expiry date"},
+		{Sheet: "Register", Row: 7, Column: "Expiry Date", Value: "soon", Reason: `unrecognized date format "soon"`},
+		{Sheet: "Register", Row: 8, Column: "Document Name", Reason: "missing document name"},
+		{Sheet: "Register", Row: 9, Column: "Issue Date", Value: "someday", Reason: `unrecognized date format "someday"`},
+		{Sheet: "Register", Row: 10, Column: "Owner", Value: "ops@example.com, not an address", Reason: "invalid email address: not an address"},
+	}
+	if len(result.Issues) != len(wantIssues) {
+		t.Fatalf("issues = %+v, want %d", result.Issues, len(wantIssues))
+	}
+	for i, want := range wantIssues {
+		if result.Issues[i] != want {
+			t.Errorf("issue %d = %+v, want %+v", i, result.Issues[i], want)
+		}
+	}
+
+	var rows []int
+	for _, doc := range result.Documents {
+		if doc.Sheet != "Register" {
+			t.Errorf("document %q is on sheet %q", doc.DocumentName, doc.Sheet)
+		}
+		rows = append(rows, doc.Row)
+	}
+	if !reflect.DeepEqual(rows, []int{4, 10, 11}) {
+		t.Errorf("document rows = %v, want [4 10 11]", rows)
+	}
+	if lease := result.Documents[1]; !reflect.DeepEqual(lease.Recipients, []string{"ops@example.com"}) {
+		t.Errorf("recipients = %v, want the valid address only", lease.Recipients)
+	}
+	if first, second := result.Documents[0], result.Documents[2]; first.ID == second.ID {
+		t.Errorf("repeated document name got the same ID %s", first.ID)
+	}
+
+	if len(result.Tabs) != 1 || result.Tabs[0].HeaderRow != 3 || len(result.Tabs[0].Headers) != 4 {
+		t.Errorf("tabs = %+v, want the header layout of the tab", result.Tabs)
+	}
+}
+
+func TestParseDocumentsRejectsSheets(t *testing.T) {
+	dateParser, err := NewDateParser(&types.SheetSettings{})
+	if err != nil {
+		t.Fatalf("NewDateParser: %v", err)
+	}
+
+	err = parseDocuments(&sheetTab{Title: "Empty", FirstRow: 1}, dateParser, &types.SheetResult{})
+	if err == nil {
+		t.Error("parseDocuments accepted a tab without rows")
+	}
+
+	tab := &sheetTab{
+		Title:    "Register",
+		FirstRow: 1,
+		Rows:     [][]interface{}{{"Document Name", "Issue Date"}, {"Passport", "2020-03-04"}},
+	}
+	err = parseDocuments(tab, dateParser, &types.SheetResult{})
+	var missing *MissingColumnsError
+	if !errors.As(err, &missing) || missing.Sheet != "Register" {
+		t.Errorf("parseDocuments error = %v, want the missing columns of the tab", err)
+	}
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lambda/api/auth"
+	"lambda/database"
+	"lambda/types"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// SettingsHandler lets signed in users change how a registered spreadsheet
+// notifies them. It runs behind the token middleware.
+type SettingsHandler struct {
+	databaseStore *database.DynamoDBStore
+}
+
+func NewSettingsHandler(dbStore *database.DynamoDBStore) *SettingsHandler {
+	return &SettingsHandler{
+		databaseStore: dbStore,
+	}
+}
+
+// channelSettingsRequest is the body of PUT /settings/channels. Webhook URLs
+// are credentials, so they are only accepted in the body of an
+// authenticated request. Empty fields remove the channel.
+type channelSettingsRequest struct {
+	SlackWebhook  string `json:"slack_webhook"`
+	TeamsWebhook  string `json:"teams_webhook"`
+	WebhookURL    string `json:"webhook_url"`
+	WebhookSecret string `json:"webhook_secret"` // Key used to sign generic webhook requests, required with webhook_url
+}
+
+type channelSettingsResponse struct {
+	SpreadsheetID string   `json:"spreadsheet_id"`
+	Channels      []string `json:"channels"` // Types of the configured channels
+}
+
+// UpdateChannels handles PUT /settings/channels?spreadsheet_id=..., replacing
+// the chat and webhook channels notified about the spreadsheet.
+func (sh *SettingsHandler) UpdateChannels(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	corsHeaders := dashboardHeaders()
+
+	session, ok := ctx.Value("session").(*auth.SessionClaims)
+	if !ok {
+		return errorResponse(http.StatusUnauthorized, "Unauthorized: Missing user identification", corsHeaders), nil
+	}
+	spreadsheetID := strings.TrimSpace(request.QueryStringParameters["spreadsheet_id"])
+	if spreadsheetID == "" {
+		return errorResponse(http.StatusBadRequest, "spreadsheet_id is missing", corsHeaders), nil
+	}
+
+	body := request.Body
+	if request.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return errorResponse(http.StatusBadRequest, "invalid request body", corsHeaders), nil
+		}
+		body = string(decoded)
+	}
+	var settings channelSettingsRequest
+	if err := json.Unmarshal([]byte(body), &settings); err != nil {
+		return errorResponse(http.StatusBadRequest, "invalid request body", corsHeaders), nil
+	}
+	channels, err := channelsFromSettings(&settings)
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err.Error(), corsHeaders), nil
+	}
+
+	spreadsheet, err := sh.databaseStore.GetSpreadsheet(session.Subject, spreadsheetID)
+	if errors.Is(err, database.ErrSpreadsheetNotFound) {
+		return errorResponse(http.StatusNotFound, "spreadsheet not found", corsHeaders), nil
+	}
+	if err != nil {
+		fmt.Printf("failed to get spreadsheet %s: %v\n", spreadsheetID, err)
+		return errorResponse(http.StatusInternalServerError, "error updating settings", corsHeaders), nil
+	}
+
+	spreadsheet.Notifications.Channels = channels
+	if err := sh.databaseStore.UpdateSpreadsheet(spreadsheet); err != nil {
+		fmt.Printf("failed to update channels of %s: %v\n", spreadsheetID, err)
+		return errorResponse(http.StatusInternalServerError, "error updating settings", corsHeaders), nil
+	}
+
+	response := channelSettingsResponse{
+		SpreadsheetID: spreadsheetID,
+		Channels:      []string{},
+	}
+	for _, channel := range channels {
+		response.Channels = append(response.Channels, channel.Type)
+	}
+	return jsonResponse(response, corsHeaders), nil
+}
+
+// Helper function to collect the chat and webhook channels to notify. Each
+// channel is given by its webhook URL; a generic webhook also needs the
+// secret its requests are signed with.
+func channelsFromSettings(settings *channelSettingsRequest) ([]types.NotificationChannel, error) {
+	var channels []types.NotificationChannel
+	for _, channel := range []types.NotificationChannel{
+		{Type: types.ChannelSlack, URL: settings.SlackWebhook},
+		{Type: types.ChannelTeams, URL: settings.TeamsWebhook},
+		{Type: types.ChannelWebhook, URL: settings.WebhookURL, Secret: settings.WebhookSecret},
+	} {
+		channel.URL = strings.TrimSpace(channel.URL)
+		if channel.URL == "" {
+			continue
+		}
+		if err := validateChannel(channel); err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Keys for the columns the sheet processor understands.
+const (
+	columnName       = "name"
+	columnIssueDate  = "issue_date"
+	columnExpiryDate = "expiry_date"
+	columnDuration   = "duration"
+	columnStatus     = "status"
+	columnOwner      = "owner"
+	columnNotify     = "notify"
+)
+
+type columnSpec struct {
+	Key      string
+	Label    string   // Header shown to users when the column is missing
+	Aliases  []string // Accepted header spellings, already normalized
+	Required bool
+}
+
+// sheetColumns lists every column we look for in the header row. Columns
+// that are not listed here are ignored, so sheets can carry extra data.
+var sheetColumns = []columnSpec{
+	{
+		Key:      columnName,
+		Label:    "Document Name",
+		Aliases:  []string{"document name", "document", "name", "doc name", "title"},
+		Required: true,
+	},
+	{
+		Key:     columnIssueDate,
+		Label:   "Issue Date",
+		Aliases: []string{"issue date", "issued", "issued on", "date issued", "issue"},
+	},
+	{
+		Key:      columnExpiryDate,
+		Label:    "Expiry Date",
+		Aliases:  []string{"expiry date", "expiry", "expires", "expires on", "expiration", "expiration date", "valid until"},
+		Required: true,
+	},
+	{
+		Key:     columnDuration,
+		Label:   "Duration",
+		Aliases: []string{"duration", "duration days", "validity", "validity days"},
+	},
+	{
+		Key:     columnStatus,
+		Label:   "Status",
+		Aliases: []string{"status", "state"},
+	},
+	{
+		Key:     columnOwner,
+		Label:   "Owner",
+		Aliases: []string{"owner", "owners", "owner email", "responsible", "assignee"},
+	},
+	{
+		Key:     columnNotify,
+		Label:   "Notify",
+		Aliases: []string{"notify", "notify email", "notify emails", "cc", "email", "emails"},
+	},
+}
+
+// columnLabel returns the user facing name of a column key.
+func columnLabel(key string) string {
+	for _, spec := range sheetColumns {
+		if spec.Key == key {
+			return spec.Label
+		}
+	}
+	return key
+}
+
+// MissingColumnsError is returned when the header row lacks required columns.
+type MissingColumnsError struct {
+	Sheet   string
+	Columns []string
+}
+
+func (e *MissingColumnsError) Error() string {
+	return fmt.Sprintf("sheet %q is missing required columns: %s", e.Sheet, strings.Join(e.Columns, ", "))
+}
+
+// columnMap maps a column key to its index in a sheet row.
+type columnMap map[string]int
+
+// mapColumns reads a header row and returns the index of every known column
+// along with the labels of required columns that could not be found.
+func mapColumns(header []interface{}) (columnMap, []string) {
+	columns := columnMap{}
+	for i, cell := range header {
+		name := normalizeHeader(fmt.Sprintf("%v", cell))
+		if name == "" {
+			continue
+		}
+		for _, spec := range sheetColumns {
+			if _, found := columns[spec.Key]; found {
+				continue
+			}
+			if containsString(spec.Aliases, name) {
+				columns[spec.Key] = i
+				break
+			}
+		}
+	}
+
+	var missing []string
+	for _, spec := range sheetColumns {
+		if _, found := columns[spec.Key]; spec.Required && !found {
+			missing = append(missing, spec.Label)
+		}
+	}
+	return columns, missing
+}
+
+// has reports whether the header row contained the given column.
+func (cm columnMap) has(key string) bool {
+	_, ok := cm[key]
+	return ok
+}
+
+// value returns the trimmed cell for the given column, or "" when the column
+// is absent or the row is shorter than the header.
+func (cm columnMap) value(row []interface{}, key string) string {
+	i, ok := cm[key]
+	if !ok || i >= len(row) || row[i] == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprintf("%v", row[i]))
+}
+
+// raw returns the untouched cell for the given column, or nil when the column
+// is absent or the row is shorter than the header.
+func (cm columnMap) raw(row []interface{}, key string) interface{} {
+	i, ok := cm[key]
+	if !ok || i >= len(row) {
+		return nil
+	}
+	return row[i]
+}
+
+// normalizeHeader lowercases a header and collapses punctuation and
+// whitespace so that "Expires On", "expires_on" and "Expires on:" all match.
+func normalizeHeader(header string) string {
+	fields := strings.FieldsFunc(strings.ToLower(header), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(fields, " ")
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
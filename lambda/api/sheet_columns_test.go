@@ -0,0 +1,86 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeHeader(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"Expiry Date", "expiry date"},
+		{"expiry_date", "expiry date"},
+		{"  Expires On: ", "expires on"},
+		{"DOC-NAME", "doc name"},
+		{"Validity (days)", "validity days"},
+		{"", ""},
+		{"---", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeHeader(tt.header); got != tt.want {
+			t.Errorf("normalizeHeader(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestMapColumns(t *testing.T) {
+	tests := []struct {
+		name        string
+		header      []interface{}
+		wantColumns columnMap
+		wantMissing []string
+	}{
+		{
+			name:        "canonical headers",
+			header:      []interface{}{"Document Name", "Issue Date", "Expiry Date", "Status"},
+			wantColumns: columnMap{columnName: 0, columnIssueDate: 1, columnExpiryDate: 2, columnStatus: 3},
+		},
+		{
+			name:        "aliases in any order with extra columns",
+			header:      []interface{}{"Notes", "Expires On", "Title", "Owner Email", "CC"},
+			wantColumns: columnMap{columnExpiryDate: 1, columnName: 2, columnOwner: 3, columnNotify: 4},
+		},
+		{
+			name:        "first matching column wins",
+			header:      []interface{}{"Name", "Expiry", "Document"},
+			wantColumns: columnMap{columnName: 0, columnExpiryDate: 1},
+		},
+		{
+			name:        "missing required columns",
+			header:      []interface{}{"Issue Date", "", nil},
+			wantColumns: columnMap{columnIssueDate: 0},
+			wantMissing: []string{"Document Name", "Expiry Date"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			columns, missing := mapColumns(tt.header)
+			if !reflect.DeepEqual(columns, tt.wantColumns) {
+				t.Errorf("columns = %v, want %v", columns, tt.wantColumns)
+			}
+			if !reflect.DeepEqual(missing, tt.wantMissing) {
+				t.Errorf("missing = %v, want %v", missing, tt.wantMissing)
+			}
+		})
+	}
+}
+
+func TestColumnMapValue(t *testing.T) {
+	columns := columnMap{columnName: 0, columnExpiryDate: 2}
+	row := []interface{}{"  Passport ", nil}
+
+	if got := columns.value(row, columnName); got != "Passport" {
+		t.Errorf("value(name) = %q, want %q", got, "Passport")
+	}
+	if got := columns.value(row, columnExpiryDate); got != "" {
+		t.Errorf("value past the end of the row = %q, want empty", got)
+	}
+	if got := columns.value(row, columnStatus); got != "" {
+		t.Errorf("value of absent column = %q, want empty", got)
+	}
+	if !columns.has(columnName) || columns.has(columnStatus) {
+		t.Errorf("has reports the wrong columns for %v", columns)
+	}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// sheetPageSize is the number of rows fetched per request when reading the
+// entire used range of a tab.
+const sheetPageSize = 500
+
+// Dates are requested as serial numbers so the result does not depend on the
+// display format chosen in the sheet.
+const (
+	valueRenderOption    = "UNFORMATTED_VALUE"
+	dateTimeRenderOption = "SERIAL_NUMBER"
+)
+
+// sheetTab holds the raw values read from a single tab.
+type sheetTab struct {
+	Title       string
+	SheetID     int64
+	ColumnCount int64
+	FirstRow    int // Sheet row number of Rows[0]
+	FirstColumn int // 0-based column index of the first cell in each row
+	Rows        [][]interface{}
+}
+
+// resolveTabs looks up the spreadsheet's title and the requested tabs. When
+// no tab is named the first tab is used.
+func (sp *SheetProcessor) resolveTabs(spreadsheetID string, sheetNames []string) (string, []*sheets.SheetProperties, error) {
+	spreadsheet, err := sp.Service.Spreadsheets.Get(spreadsheetID).
+		Fields("properties.title", "sheets.properties(sheetId,title,gridProperties)").
+		Do()
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to retrieve spreadsheet: %v", err)
+	}
+	if len(spreadsheet.Sheets) == 0 {
+		return "", nil, fmt.Errorf("spreadsheet has no sheets")
+	}
+	var title string
+	if spreadsheet.Properties != nil {
+		title = spreadsheet.Properties.Title
+	}
+
+	if len(sheetNames) == 0 {
+		return title, []*sheets.SheetProperties{spreadsheet.Sheets[0].Properties}, nil
+	}
+
+	var tabs []*sheets.SheetProperties
+	for _, name := range sheetNames {
+		var found *sheets.SheetProperties
+		for _, sheet := range spreadsheet.Sheets {
+			if strings.EqualFold(sheet.Properties.Title, name) {
+				found = sheet.Properties
+				break
+			}
+		}
+		if found == nil {
+			return "", nil, fmt.Errorf("sheet %q not found in spreadsheet", name)
+		}
+		tabs = append(tabs, found)
+	}
+	return title, tabs, nil
+}
+
+// readTab fetches the values of a tab. An explicit A1 range is read in one
+// request; otherwise the whole grid is paged through sheetPageSize rows at a
+// time so large registers do not hit response size limits.
+func (sp *SheetProcessor) readTab(spreadsheetID string, tab *sheets.SheetProperties, readRange string) (*sheetTab, error) {
+	result := &sheetTab{Title: tab.Title, SheetID: tab.SheetId, FirstRow: 1}
+	if tab.GridProperties != nil {
+		result.ColumnCount = tab.GridProperties.ColumnCount
+	}
+
+	if readRange != "" {
+		resp, err := sp.Service.Spreadsheets.Values.Get(spreadsheetID, qualifyRange(tab.Title, readRange)).
+			ValueRenderOption(valueRenderOption).
+			DateTimeRenderOption(dateTimeRenderOption).
+			Do()
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve data from sheet %q: %v", tab.Title, err)
+		}
+		result.Rows = resp.Values
+		result.FirstColumn, result.FirstRow = rangeStart(resp.Range)
+		return result, nil
+	}
+
+	rowCount := int64(sheetPageSize)
+	if tab.GridProperties != nil && tab.GridProperties.RowCount > 0 {
+		rowCount = tab.GridProperties.RowCount
+	}
+
+	for start := int64(1); start <= rowCount; start += sheetPageSize {
+		end := start + sheetPageSize - 1
+		if end > rowCount {
+			end = rowCount
+		}
+		pageRange := fmt.Sprintf("%s!%d:%d", quoteSheetName(tab.Title), start, end)
+		resp, err := sp.Service.Spreadsheets.Values.Get(spreadsheetID, pageRange).
+			ValueRenderOption(valueRenderOption).
+			DateTimeRenderOption(dateTimeRenderOption).
+			Do()
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve data from sheet %q: %v", tab.Title, err)
+		}
+		if len(resp.Values) == 0 {
+			continue
+		}
+
+		// Trailing blank rows are dropped from each page, so pad up to the
+		// page start to keep row positions aligned with the sheet.
+		for int64(len(result.Rows)) < start-1 {
+			result.Rows = append(result.Rows, nil)
+		}
+		result.Rows = append(result.Rows, resp.Values...)
+	}
+	return result, nil
+}
+
+// qualifyRange prefixes a range with its tab unless it already names one.
+func qualifyRange(title, readRange string) string {
+	if strings.Contains(readRange, "!") {
+		return readRange
+	}
+	return quoteSheetName(title) + "!" + readRange
+}
+
+// quoteSheetName quotes a tab title for use in A1 notation.
+func quoteSheetName(title string) string {
+	return "'" + strings.ReplaceAll(title, "'", "''") + "'"
+}
+
+// rangeStart returns the 0-based column index and 1-based row number of the
+// top left cell of an A1 range such as "'Sheet 1'!B5:F100". Missing parts
+// default to column A and row 1.
+func rangeStart(a1Range string) (int, int) {
+	if i := strings.LastIndex(a1Range, "!"); i >= 0 {
+		a1Range = a1Range[i+1:]
+	}
+	start := strings.ToUpper(strings.SplitN(a1Range, ":", 2)[0])
+
+	column := 0
+	i := 0
+	for ; i < len(start) && start[i] >= 'A' && start[i] <= 'Z'; i++ {
+		column = column*26 + int(start[i]-'A'+1)
+	}
+	if column > 0 {
+		column--
+	}
+
+	row, err := strconv.Atoi(start[i:])
+	if err != nil || row < 1 {
+		row = 1
+	}
+	return column, row
+}
+
+// columnLetter converts a 0-based column index into its A1 letters.
+func columnLetter(index int) string {
+	letters := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		letters = string(rune('A'+(index-1)%26)) + letters
+	}
+	return letters
+}
@@ -0,0 +1,72 @@
+package api
+
+import "testing"
+
+func TestRangeStart(t *testing.T) {
+	tests := []struct {
+		a1Range    string
+		wantColumn int
+		wantRow    int
+	}{
+		{"'Sheet 1'!B5:F100", 1, 5},
+		{"A1:C3", 0, 1},
+		{"Sheet1!Z10:AB20", 25, 10},
+		{"Sheet1!AA3:AC", 26, 3},
+		{"'Tab'!az2", 51, 2},
+		{"'It''s'!C:E", 2, 1},
+		{"B:D", 1, 1},
+		{"5:10", 0, 5},
+		{"'Q1!Q2'!D4", 3, 4},
+		{"Sheet1!A0", 0, 1},
+		{"", 0, 1},
+	}
+	for _, tt := range tests {
+		column, row := rangeStart(tt.a1Range)
+		if column != tt.wantColumn || row != tt.wantRow {
+			t.Errorf("rangeStart(%q) = %d, %d, want %d, %d", tt.a1Range, column, row, tt.wantColumn, tt.wantRow)
+		}
+	}
+}
+
+func TestQualifyRange(t *testing.T) {
+	tests := []struct {
+		title     string
+		readRange string
+		want      string
+	}{
+		{"Sheet1", "A1:C10", "'Sheet1'!A1:C10"},
+		{"Team documents", "B:F", "'Team documents'!B:F"},
+		{"It's due", "A2:D", "'It''s due'!A2:D"},
+		{"Sheet1", "'Other tab'!A1:C10", "'Other tab'!A1:C10"},
+	}
+	for _, tt := range tests {
+		if got := qualifyRange(tt.title, tt.readRange); got != tt.want {
+			t.Errorf("qualifyRange(%q, %q) = %q, want %q", tt.title, tt.readRange, got, tt.want)
+		}
+	}
+}
+
+func TestColumnLetter(t *testing.T) {
+	tests := []struct {
+		index int
+		want  string
+	}{
+		{0, "A"},
+		{1, "B"},
+		{25, "Z"},
+		{26, "AA"},
+		{27, "AB"},
+		{51, "AZ"},
+		{52, "BA"},
+		{701, "ZZ"},
+		{702, "AAA"},
+	}
+	for _, tt := range tests {
+		if got := columnLetter(tt.index); got != tt.want {
+			t.Errorf("columnLetter(%d) = %q, want %q", tt.index, got, tt.want)
+		}
+		if column, _ := rangeStart(tt.want + "1"); column != tt.index {
+			t.Errorf("rangeStart(%q) = %d, want column %d", tt.want+"1", column, tt.index)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package api
+
+import (
+	"fmt"
+	"lambda/types"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// Headers of the columns written back to the sheet. Existing columns are
+// matched by alias; missing ones are added after the last header.
+var writeBackColumns = []columnSpec{
+	{Key: columnStatus, Label: "Status", Aliases: []string{"status", "state"}},
+	{Key: "days_left", Label: "Days Left", Aliases: []string{"days left", "days remaining", "days to expiry"}},
+	{Key: "last_checked", Label: "Last Checked", Aliases: []string{"last checked", "checked", "checked on"}},
+}
+
+// Row colors applied by conditional formatting.
+var (
+	expiredRowColor  = &sheets.Color{Red: 0.96, Green: 0.8, Blue: 0.8}
+	expiringRowColor = &sheets.Color{Red: 1, Green: 0.95, Blue: 0.8}
+)
+
+type SheetWriter struct {
+	Service *sheets.Service
+}
+
+func NewSheetWriter(service *sheets.Service) *SheetWriter {
+	return &SheetWriter{
+		Service: service,
+	}
+}
+
+// WriteStatus writes each document's computed status, days left and the
+// check time into its row, and optionally colors expired and expiring rows.
+func (sw *SheetWriter) WriteStatus(settings *types.SheetSettings, result *types.SheetResult, checkedAt time.Time) error {
+	if settings.Timezone != "" {
+		if loc, err := time.LoadLocation(settings.Timezone); err == nil {
+			checkedAt = checkedAt.In(loc)
+		}
+	}
+
+	var data []*sheets.ValueRange
+	var formatRequests []*sheets.Request
+	for _, tab := range result.Tabs {
+		columns, newHeaders := writeBackLayout(tab)
+
+		// Grow the grid when the new columns do not fit
+		lastColumn := int64(tab.FirstColumn + len(tab.Headers) + len(newHeaders))
+		if len(newHeaders) > 0 && lastColumn > tab.ColumnCount {
+			formatRequests = append(formatRequests, &sheets.Request{
+				AppendDimension: &sheets.AppendDimensionRequest{
+					SheetId:         tab.SheetID,
+					Dimension:       "COLUMNS",
+					Length:          lastColumn - tab.ColumnCount,
+					ForceSendFields: []string{"SheetId"},
+				},
+			})
+		}
+		for i, header := range newHeaders {
+			column := tab.FirstColumn + len(tab.Headers) + i
+			data = append(data, cellValue(tab.Title, column, tab.HeaderRow, header))
+		}
+
+		for _, doc := range result.Documents {
+			if doc.Sheet != tab.Title {
+				continue
+			}
+			data = append(data,
+				cellValue(tab.Title, columns[columnStatus], doc.Row, doc.Status),
+				cellValue(tab.Title, columns["days_left"], doc.Row, doc.DaysRemaining),
+				cellValue(tab.Title, columns["last_checked"], doc.Row, checkedAt.Format("2006-01-02 15:04")),
+			)
+		}
+
+		if settings.ColorRows {
+			rules, err := sw.statusFormatRules(settings.SpreadsheetID, tab, columns[columnStatus])
+			if err != nil {
+				return err
+			}
+			formatRequests = append(formatRequests, rules...)
+		}
+	}
+
+	// New columns have to exist before values can be written into them
+	if len(formatRequests) > 0 {
+		_, err := sw.Service.Spreadsheets.BatchUpdate(settings.SpreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+			Requests: formatRequests,
+		}).Do()
+		if err != nil {
+			return fmt.Errorf("unable to update sheet layout: %v", err)
+		}
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+	_, err := sw.Service.Spreadsheets.Values.BatchUpdate(settings.SpreadsheetID, &sheets.BatchUpdateValuesRequest{
+		ValueInputOption: "USER_ENTERED",
+		Data:             data,
+	}).Do()
+	if err != nil {
+		return fmt.Errorf("unable to write status to sheet: %v", err)
+	}
+	return nil
+}
+
+// writeBackLayout returns the column index of every write-back column and the
+// headers that have to be added because the tab does not have them yet.
+func writeBackLayout(tab *types.SheetTab) (map[string]int, []string) {
+	columns := map[string]int{}
+	for i, header := range tab.Headers {
+		name := normalizeHeader(header)
+		for _, spec := range writeBackColumns {
+			if _, found := columns[spec.Key]; !found && containsString(spec.Aliases, name) {
+				columns[spec.Key] = tab.FirstColumn + i
+			}
+		}
+	}
+
+	var newHeaders []string
+	for _, spec := range writeBackColumns {
+		if _, found := columns[spec.Key]; !found {
+			columns[spec.Key] = tab.FirstColumn + len(tab.Headers) + len(newHeaders)
+			newHeaders = append(newHeaders, spec.Label)
+		}
+	}
+	return columns, newHeaders
+}
+
+// statusFormatRules returns the conditional formatting requests that color
+// expired and expiring rows, skipping rules the tab already has.
+func (sw *SheetWriter) statusFormatRules(spreadsheetID string, tab *types.SheetTab, statusColumn int) ([]*sheets.Request, error) {
+	spreadsheet, err := sw.Service.Spreadsheets.Get(spreadsheetID).
+		Fields("sheets(properties.sheetId,conditionalFormats)").
+		Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve conditional formats: %v", err)
+	}
+
+	existing := map[string]bool{}
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties == nil || sheet.Properties.SheetId != tab.SheetID {
+			continue
+		}
+		for _, rule := range sheet.ConditionalFormats {
+			if rule.BooleanRule == nil || rule.BooleanRule.Condition == nil {
+				continue
+			}
+			for _, value := range rule.BooleanRule.Condition.Values {
+				existing[value.UserEnteredValue] = true
+			}
+		}
+	}
+
+	// Rules cover every data row below the header across the whole grid width
+	gridRange := &sheets.GridRange{
+		SheetId:         tab.SheetID,
+		StartRowIndex:   int64(tab.HeaderRow),
+		ForceSendFields: []string{"SheetId"},
+	}
+
+	var requests []*sheets.Request
+	for _, rule := range []struct {
+		Status string
+		Color  *sheets.Color
+	}{
+		{types.StatusExpired, expiredRowColor},
+		{types.StatusExpiringSoon, expiringRowColor},
+	} {
+		formula := fmt.Sprintf(`=$%s%d="%s"`, columnLetter(statusColumn), tab.HeaderRow+1, rule.Status)
+		if existing[formula] {
+			continue
+		}
+		requests = append(requests, &sheets.Request{
+			AddConditionalFormatRule: &sheets.AddConditionalFormatRuleRequest{
+				Index: 0,
+				Rule: &sheets.ConditionalFormatRule{
+					Ranges: []*sheets.GridRange{gridRange},
+					BooleanRule: &sheets.BooleanRule{
+						Condition: &sheets.BooleanCondition{
+							Type:   "CUSTOM_FORMULA",
+							Values: []*sheets.ConditionValue{{UserEnteredValue: formula}},
+						},
+						Format: &sheets.CellFormat{BackgroundColor: rule.Color},
+					},
+				},
+			},
+		})
+	}
+	return requests, nil
+}
+
+// cellValue builds a single cell update in A1 notation.
+func cellValue(title string, column, row int, value interface{}) *sheets.ValueRange {
+	return &sheets.ValueRange{
+		Range:  fmt.Sprintf("%s!%s%d", quoteSheetName(title), columnLetter(column), row),
+		Values: [][]interface{}{{value}},
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"lambda/types"
+	"reflect"
+	"testing"
+)
+
+func TestWriteBackLayout(t *testing.T) {
+	tests := []struct {
+		name           string
+		tab            types.SheetTab
+		wantColumns    map[string]int
+		wantNewHeaders []string
+	}{
+		{
+			name:           "adds every column after the headers",
+			tab:            types.SheetTab{Headers: []string{"Document Name", "Expiry Date"}},
+			wantColumns:    map[string]int{columnStatus: 2, "days_left": 3, "last_checked": 4},
+			wantNewHeaders: []string{"Status", "Days Left", "Last Checked"},
+		},
+		{
+			name:        "reuses existing columns by alias",
+			tab:         types.SheetTab{Headers: []string{"Days remaining", "Document Name", "State", "Expiry Date", "Checked on"}},
+			wantColumns: map[string]int{columnStatus: 2, "days_left": 0, "last_checked": 4},
+		},
+		{
+			name:           "first matching column wins",
+			tab:            types.SheetTab{Headers: []string{"Status", "Document Name", "Status"}},
+			wantColumns:    map[string]int{columnStatus: 0, "days_left": 3, "last_checked": 4},
+			wantNewHeaders: []string{"Days Left", "Last Checked"},
+		},
+		{
+			name:           "range not starting in column A",
+			tab:            types.SheetTab{FirstColumn: 2, Headers: []string{"Document Name", "Expiry Date", "Status"}},
+			wantColumns:    map[string]int{columnStatus: 4, "days_left": 5, "last_checked": 6},
+			wantNewHeaders: []string{"Days Left", "Last Checked"},
+		},
+		{
+			name: "new columns past Z",
+			tab: types.SheetTab{FirstColumn: 20, Headers: []string{
+				"Document Name", "Expiry Date", "Owner", "Notify", "Notes", "Issue Date",
+			}},
+			wantColumns:    map[string]int{columnStatus: 26, "days_left": 27, "last_checked": 28},
+			wantNewHeaders: []string{"Status", "Days Left", "Last Checked"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			columns, newHeaders := writeBackLayout(&tt.tab)
+			if !reflect.DeepEqual(columns, tt.wantColumns) {
+				t.Errorf("columns = %v, want %v", columns, tt.wantColumns)
+			}
+			if !reflect.DeepEqual(newHeaders, tt.wantNewHeaders) {
+				t.Errorf("new headers = %q, want %q", newHeaders, tt.wantNewHeaders)
+			}
+		})
+	}
+}
+
+func TestCellValue(t *testing.T) {
+	tests := []struct {
+		title  string
+		column int
+		row    int
+		want   string
+	}{
+		{"Sheet1", 0, 1, "'Sheet1'!A1"},
+		{"Sheet1", 25, 4, "'Sheet1'!Z4"},
+		{"Sheet1", 26, 4, "'Sheet1'!AA4"},
+		{"Bob's documents", 27, 12, "'Bob''s documents'!AB12"},
+	}
+	for _, tt := range tests {
+		cell := cellValue(tt.title, tt.column, tt.row, "Expired")
+		if cell.Range != tt.want {
+			t.Errorf("cellValue(%q, %d, %d) range = %q, want %q", tt.title, tt.column, tt.row, cell.Range, tt.want)
+		}
+		if !reflect.DeepEqual(cell.Values, [][]interface{}{{"Expired"}}) {
+			t.Errorf("cellValue values = %v", cell.Values)
+		}
+	}
+}
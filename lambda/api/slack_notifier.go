@@ -0,0 +1,107 @@
+package api
+
+import (
+	"fmt"
+	"lambda/types"
+	"net/http"
+	"strings"
+)
+
+// Slack rejects section blocks longer than this many characters.
+const maxSlackSectionText = 3000
+
+// SlackNotifier posts to a Slack incoming webhook.
+type SlackNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+func NewSlackNotifier(webhookURL string) *SlackNotifier {
+	return &SlackNotifier{
+		WebhookURL: webhookURL,
+		Client:     notifierHTTPClient,
+	}
+}
+
+type slackMessage struct {
+	Text   string       `json:"text"` // Shown in notifications and by clients without blocks
+	Blocks []slackBlock `json:"blocks,omitempty"`
+}
+
+type slackBlock struct {
+	Type string     `json:"type"`
+	Text *slackText `json:"text,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+func (sn *SlackNotifier) SendDocumentSummary(result *types.SheetResult) error {
+	message := slackDocumentMessage(summaryHeadline(result), result.Documents)
+	if len(result.Issues) > 0 {
+		message.Blocks = append(message.Blocks, slackSection(fmt.Sprintf("_%d row(s) could not be tracked._", len(result.Issues))))
+	}
+	return postJSON(sn.Client, sn.WebhookURL, message, nil)
+}
+
+func (sn *SlackNotifier) SendDocumentReminders(docs []*types.Document) error {
+	return postJSON(sn.Client, sn.WebhookURL, slackDocumentMessage(remindersHeadline(docs), docs), nil)
+}
+
+// slackDocumentMessage lists documents under a headline.
+func slackDocumentMessage(headline string, documents []*types.Document) *slackMessage {
+	docs, omitted := chatDocuments(documents)
+
+	var lines []string
+	for _, doc := range docs {
+		lines = append(lines, fmt.Sprintf("• *%s* – %s, %s", slackEscape(doc.DocumentName), doc.Status, expiryDescription(doc)))
+	}
+	if omitted > 0 {
+		lines = append(lines, fmt.Sprintf("_…and %d more_", omitted))
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "No documents found.")
+	}
+
+	message := &slackMessage{
+		Text: headline,
+		Blocks: []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: truncate(headline, 150)}},
+		},
+	}
+
+	// Split the list over several sections to stay within Slack's limits
+	var section strings.Builder
+	for _, line := range lines {
+		if section.Len()+len(line)+1 > maxSlackSectionText {
+			message.Blocks = append(message.Blocks, slackSection(section.String()))
+			section.Reset()
+		}
+		if section.Len() > 0 {
+			section.WriteString("\n")
+		}
+		section.WriteString(line)
+	}
+	message.Blocks = append(message.Blocks, slackSection(section.String()))
+	return message
+}
+
+func slackSection(text string) slackBlock {
+	return slackBlock{Type: "section", Text: &slackText{Type: "mrkdwn", Text: text}}
+}
+
+// slackEscape escapes the characters Slack treats as markup.
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// truncate shortens text to at most max runes.
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-1]) + "…"
+}
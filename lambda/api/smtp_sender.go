@@ -0,0 +1,250 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"lambda/api/auth"
+	"lambda/types"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Email backends selectable with EMAIL_BACKEND.
+const (
+	emailBackendGmail = "gmail"
+	emailBackendSMTP  = "smtp"
+)
+
+const smtpDialTimeout = 10 * time.Second
+
+// EmailBackend returns the configured email backend. Gmail, sending as the
+// signed in user, is the default.
+func EmailBackend() string {
+	if strings.EqualFold(os.Getenv("EMAIL_BACKEND"), emailBackendSMTP) {
+		return emailBackendSMTP
+	}
+	return emailBackendGmail
+}
+
+// SMTPConfig describes the shared mailbox notifications are sent from.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string // Sender address
+	FromName string // Display name shown in the From header
+
+	// StartTLS upgrades plain connections and refuses servers that do not
+	// support it. Port 465 always uses implicit TLS.
+	StartTLS bool
+}
+
+// SMTPConfigFromEnv reads the SMTP settings from SMTP_HOST, SMTP_PORT,
+// SMTP_USERNAME, SMTP_PASSWORD, SMTP_FROM and SMTP_STARTTLS. SMTP_PASSWORD
+// may refer to a Secrets Manager secret or SSM parameter (see
+// auth.ConfigValue), so the password stays out of the function's
+// environment.
+func SMTPConfigFromEnv() (*SMTPConfig, error) {
+	password, err := auth.ConfigValue("SMTP_PASSWORD")
+	if err != nil {
+		return nil, err
+	}
+	config := &SMTPConfig{
+		Host:     os.Getenv("SMTP_HOST"),
+		Port:     587,
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: password,
+		From:     os.Getenv("SMTP_FROM"),
+		FromName: os.Getenv("EMAIL_FROM_NAME"),
+		StartTLS: os.Getenv("SMTP_STARTTLS") != "false",
+	}
+	if config.Host == "" {
+		return nil, errors.New("SMTP_HOST is not set")
+	}
+	if port := os.Getenv("SMTP_PORT"); port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("invalid SMTP_PORT %q", port)
+		}
+		config.Port = n
+	}
+	if config.From == "" {
+		config.From = config.Username
+	}
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP_FROM %q", config.From)
+	}
+	config.From = from.Address
+	if config.FromName == "" {
+		config.FromName = defaultFromName
+	}
+	return config, nil
+}
+
+// SMTPSender emails notifications from a shared mailbox instead of the
+// user's Gmail account, so the gmail.send scope is not needed. Replies go
+// to the spreadsheet owner.
+type SMTPSender struct {
+	Config   *SMTPConfig
+	UserInfo *types.UserInfo
+	Cc       []string           // Distribution list copied on every email
+	Thread   *types.EmailThread // Conversation summaries are kept in, if any
+}
+
+func NewSMTPSender(config *SMTPConfig, userInfo *types.UserInfo) *SMTPSender {
+	return &SMTPSender{
+		Config:   config,
+		UserInfo: userInfo,
+	}
+}
+
+// SendDocumentSummary emails the summary of a processed sheet to the owner,
+// copying the distribution list. When Thread is set the summary references
+// the previous one so mail clients show them as one conversation.
+func (ss *SMTPSender) SendDocumentSummary(result *types.SheetResult) error {
+	subject, email, err := summaryEmail(result)
+	if err != nil {
+		return err
+	}
+	to := []string{ss.UserInfo.Email}
+	return ss.send(subject, email, to, excludeAddresses(ss.Cc, to), ss.Thread)
+}
+
+// SendDocumentReminders emails the documents that crossed a reminder
+// threshold, one email per set of document owners.
+func (ss *SMTPSender) SendDocumentReminders(docs []*types.Document) error {
+	var errs []error
+	for _, group := range groupReminders(ss.UserInfo.Email, ss.Cc, docs) {
+		subject, email, err := reminderEmail(group.Documents)
+		if err != nil {
+			return err
+		}
+		if err := ss.send(subject, email, group.To, group.Cc, nil); err != nil {
+			errs = append(errs, fmt.Errorf("error sending reminder email to %s: %w", strings.Join(group.To, ", "), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (ss *SMTPSender) send(subject string, email *renderedEmail, to, cc []string, thread *types.EmailThread) error {
+	messageID, err := newMessageID(ss.Config.From)
+	if err != nil {
+		return err
+	}
+
+	headers := &emailHeaders{
+		From:      (&mail.Address{Name: ss.Config.FromName, Address: ss.Config.From}).String(),
+		To:        to,
+		Cc:        cc,
+		ReplyTo:   ss.UserInfo.Email,
+		Subject:   subject,
+		MessageID: messageID,
+	}
+	if thread != nil {
+		headers.InReplyTo = thread.MessageID
+	}
+	message, err := composeEmail(headers, email)
+	if err != nil {
+		return err
+	}
+
+	if err := ss.deliver(append(append([]string{}, to...), cc...), message); err != nil {
+		return err
+	}
+	if thread != nil {
+		thread.MessageID = messageID
+	}
+	return nil
+}
+
+// deliver hands a message to the SMTP server.
+func (ss *SMTPSender) deliver(recipients []string, message []byte) error {
+	config := ss.Config
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	tlsConfig := &tls.Config{ServerName: config.Host}
+
+	var conn net.Conn
+	var err error
+	if config.Port == 465 {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: smtpDialTimeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, smtpDialTimeout)
+	}
+	if err != nil {
+		return fmt.Errorf("error connecting to SMTP server: %w", err)
+	}
+
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("error connecting to SMTP server: %w", err)
+	}
+	defer client.Close()
+
+	if config.StartTLS && config.Port != 465 {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return errors.New("SMTP server does not support STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("error starting TLS: %w", err)
+		}
+	}
+
+	if config.Username != "" {
+		auth := smtp.PlainAuth("", config.Username, config.Password, config.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("error authenticating with SMTP server: %w", err)
+		}
+	}
+
+	if err := client.Mail(config.From); err != nil {
+		return fmt.Errorf("error sending MAIL FROM: %w", err)
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("error adding recipient %s: %w", recipient, err)
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("error starting message data: %w", err)
+	}
+	if _, err := writer.Write(message); err != nil {
+		writer.Close()
+		return fmt.Errorf("error writing message: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("error sending message: %w", err)
+	}
+
+	// The server has accepted the message; a failed QUIT is not worth a resend
+	if err := client.Quit(); err != nil {
+		fmt.Printf("failed to close SMTP session: %v\n", err)
+	}
+	return nil
+}
+
+// newMessageID generates a unique Message-ID in the sender's domain.
+func newMessageID(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating message id: %w", err)
+	}
+
+	domain := "docexpiry"
+	if at := strings.LastIndex(from, "@"); at >= 0 && at < len(from)-1 {
+		domain = from[at+1:]
+	}
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain), nil
+}
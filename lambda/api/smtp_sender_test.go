@@ -0,0 +1,279 @@
+package api
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"lambda/types"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testSMTPServer is a minimal SMTP server standing in for a mail provider.
+// It offers AUTH PLAIN but not STARTTLS and records what it receives.
+type testSMTPServer struct {
+	listener net.Listener
+
+	mu         sync.Mutex
+	auth       string // Decoded AUTH PLAIN response
+	from       string
+	recipients []string
+	messages   [][]byte
+}
+
+func newTestSMTPServer(t *testing.T) *testSMTPServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	server := &testSMTPServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go server.serve()
+	return server
+}
+
+func (s *testSMTPServer) config() *SMTPConfig {
+	return &SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     s.listener.Addr().(*net.TCPAddr).Port,
+		Username: "docexpiry@example.com",
+		Password: "mailbox-password",
+		From:     "docexpiry@example.com",
+		FromName: "DocExpiry",
+	}
+}
+
+func (s *testSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 test ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"):
+			reply("250-test")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(command, "AUTH PLAIN "):
+			decoded, _ := base64.StdEncoding.DecodeString(line[len("AUTH PLAIN "):])
+			s.mu.Lock()
+			s.auth = string(decoded)
+			s.mu.Unlock()
+			reply("235 Authenticated")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			s.mu.Lock()
+			s.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			s.mu.Unlock()
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			s.mu.Lock()
+			s.recipients = append(s.recipients, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			s.mu.Unlock()
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 Go ahead")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(dataLine, "."))
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, []byte(data.String()))
+			s.mu.Unlock()
+			reply("250 Queued")
+		case command == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Not implemented")
+		}
+	}
+}
+
+func TestSMTPSenderSendDocumentSummary(t *testing.T) {
+	server := newTestSMTPServer(t)
+	sender := NewSMTPSender(server.config(), &types.UserInfo{Email: "owner@example.com"})
+	sender.Cc = []string{"team@example.com", "Owner@example.com"}
+	sender.Thread = &types.EmailThread{MessageID: "<previous@example.com>"}
+
+	result := &types.SheetResult{Title: "Team documents", Documents: testDocuments()}
+	if err := sender.SendDocumentSummary(result); err != nil {
+		t.Fatalf("SendDocumentSummary: %v", err)
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.auth != "\x00docexpiry@example.com\x00mailbox-password" {
+		t.Errorf("AUTH PLAIN = %q", server.auth)
+	}
+	if server.from != "docexpiry@example.com" {
+		t.Errorf("MAIL FROM = %q", server.from)
+	}
+	if strings.Join(server.recipients, ",") != "owner@example.com,team@example.com" {
+		t.Errorf("RCPT TO = %v, want the owner and the distribution list once each", server.recipients)
+	}
+	if len(server.messages) != 1 {
+		t.Fatalf("server received %d messages, want 1", len(server.messages))
+	}
+
+	message, err := mail.ReadMessage(strings.NewReader(string(server.messages[0])))
+	if err != nil {
+		t.Fatalf("parsing message: %v", err)
+	}
+	for header, want := range map[string]string{
+		"From":        `"DocExpiry" <docexpiry@example.com>`,
+		"To":          "<owner@example.com>",
+		"Cc":          "<team@example.com>",
+		"Reply-To":    "<owner@example.com>",
+		"Subject":     "Document Summary: Team documents",
+		"In-Reply-To": "<previous@example.com>",
+	} {
+		if got := message.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	messageID := message.Header.Get("Message-ID")
+	if !strings.HasSuffix(messageID, "@example.com>") {
+		t.Errorf("Message-ID = %q, want one in the sender's domain", messageID)
+	}
+	if sender.Thread.MessageID != messageID {
+		t.Errorf("thread Message-ID = %q, want the sent message's %q", sender.Thread.MessageID, messageID)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, want multipart/alternative", message.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(message.Body, params["boundary"])
+	var partTypes []string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("reading part: %v", err)
+		}
+		content, _ := io.ReadAll(part)
+		if !strings.Contains(string(content), "Passport") {
+			t.Errorf("%s part does not list the documents", part.Header.Get("Content-Type"))
+		}
+		partTypes = append(partTypes, part.Header.Get("Content-Type"))
+	}
+	if strings.Join(partTypes, ",") != "text/plain; charset=UTF-8,text/html; charset=UTF-8" {
+		t.Errorf("parts = %v, want the text then the HTML version", partTypes)
+	}
+}
+
+func TestSMTPSenderRequiresStartTLS(t *testing.T) {
+	server := newTestSMTPServer(t)
+	config := server.config()
+	config.StartTLS = true
+	sender := NewSMTPSender(config, &types.UserInfo{Email: "owner@example.com"})
+
+	err := sender.SendDocumentReminders(testDocuments())
+	if err == nil || !strings.Contains(err.Error(), "does not support STARTTLS") {
+		t.Errorf("SendDocumentReminders error = %v, want STARTTLS refused", err)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.auth != "" || len(server.messages) != 0 {
+		t.Errorf("credentials or mail sent over a plain connection")
+	}
+}
+
+func TestSMTPConfigFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    SMTPConfig
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			env:  map[string]string{"SMTP_HOST": "smtp.example.com", "SMTP_USERNAME": "bot@example.com", "SMTP_PASSWORD": "secret"},
+			want: SMTPConfig{Host: "smtp.example.com", Port: 587, Username: "bot@example.com", Password: "secret", From: "bot@example.com", FromName: defaultFromName, StartTLS: true},
+		},
+		{
+			name: "explicit sender and port",
+			env:  map[string]string{"SMTP_HOST": "smtp.example.com", "SMTP_PORT": "465", "SMTP_FROM": "Alerts <alerts@example.com>", "SMTP_STARTTLS": "false", "EMAIL_FROM_NAME": "Expiry Bot"},
+			want: SMTPConfig{Host: "smtp.example.com", Port: 465, From: "alerts@example.com", FromName: "Expiry Bot"},
+		},
+		{name: "missing host", env: map[string]string{"SMTP_FROM": "alerts@example.com"}, wantErr: true},
+		{name: "invalid port", env: map[string]string{"SMTP_HOST": "smtp.example.com", "SMTP_PORT": "smtp", "SMTP_FROM": "alerts@example.com"}, wantErr: true},
+		{name: "invalid sender", env: map[string]string{"SMTP_HOST": "smtp.example.com", "SMTP_FROM": "not an address"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"SMTP_HOST", "SMTP_PORT", "SMTP_USERNAME", "SMTP_PASSWORD", "SMTP_FROM", "SMTP_STARTTLS", "EMAIL_FROM_NAME"} {
+				t.Setenv(name, tt.env[name])
+			}
+			config, err := SMTPConfigFromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("SMTPConfigFromEnv() = %+v, want an error", config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SMTPConfigFromEnv: %v", err)
+			}
+			if *config != tt.want {
+				t.Errorf("SMTPConfigFromEnv() = %+v, want %+v", *config, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMessageID(t *testing.T) {
+	tests := []struct {
+		from       string
+		wantSuffix string
+	}{
+		{"alerts@example.com", "@example.com>"},
+		{"no-domain", "@docexpiry>"},
+		{"trailing@", "@docexpiry>"},
+	}
+	for _, tt := range tests {
+		id, err := newMessageID(tt.from)
+		if err != nil {
+			t.Fatalf("newMessageID(%q): %v", tt.from, err)
+		}
+		if !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, tt.wantSuffix) {
+			t.Errorf("newMessageID(%q) = %q, want suffix %q", tt.from, id, tt.wantSuffix)
+		}
+	}
+	first, _ := newMessageID("a@example.com")
+	second, _ := newMessageID("a@example.com")
+	if first == second {
+		t.Errorf("newMessageID returned %q twice", first)
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"lambda/types"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Warning windows used when a spreadsheet does not configure its own.
+var defaultWarningDays = []int{90, 30, 7}
+
+// Sheet status spellings mapped onto the computed statuses. Values that are
+// not listed here are not compared.
+var sheetStatusAliases = map[string]string{
+	"expired":       types.StatusExpired,
+	"lapsed":        types.StatusExpired,
+	"expiring":      types.StatusExpiringSoon,
+	"expiring soon": types.StatusExpiringSoon,
+	"due":           types.StatusExpiringSoon,
+	"due soon":      types.StatusExpiringSoon,
+	"renew":         types.StatusExpiringSoon,
+	"valid":         types.StatusValid,
+	"active":        types.StatusValid,
+	"current":       types.StatusValid,
+	"ok":            types.StatusValid,
+}
+
+// StatusEngine derives a document's status from its expiry date relative to
+// a reference time.
+type StatusEngine struct {
+	Now         time.Time
+	WarningDays []int // Sorted from the widest window to the narrowest
+}
+
+// NewStatusEngine creates an engine evaluating documents as of now, in the
+// spreadsheet's timezone so "today" matches what the sheet's users see.
+func NewStatusEngine(now time.Time, settings *types.SheetSettings) *StatusEngine {
+	if settings.Timezone != "" {
+		if loc, err := time.LoadLocation(settings.Timezone); err == nil {
+			now = now.In(loc)
+		}
+	}
+
+	var warningDays []int
+	for _, days := range settings.WarningDays {
+		if days > 0 {
+			warningDays = append(warningDays, days)
+		}
+	}
+	if len(warningDays) == 0 {
+		warningDays = append(warningDays, defaultWarningDays...)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(warningDays)))
+
+	return &StatusEngine{
+		Now:         now,
+		WarningDays: warningDays,
+	}
+}
+
+// Evaluate sets the computed status fields on every document.
+func (se *StatusEngine) Evaluate(docs []*types.Document) {
+	for _, doc := range docs {
+		se.evaluate(doc)
+	}
+}
+
+func (se *StatusEngine) evaluate(doc *types.Document) {
+	doc.WarningWindow = 0
+	if doc.ExpiryDate.IsZero() {
+		doc.Status = types.StatusUnknown
+		doc.DaysRemaining = 0
+		doc.StatusMismatch = false
+		return
+	}
+
+	doc.DaysRemaining = daysBetween(se.Now, doc.ExpiryDate)
+	switch {
+	case doc.DaysRemaining < 0:
+		doc.Status = types.StatusExpired
+	case doc.DaysRemaining <= se.WarningDays[0]:
+		doc.Status = types.StatusExpiringSoon
+		for _, days := range se.WarningDays {
+			if doc.DaysRemaining <= days {
+				doc.WarningWindow = days
+			}
+		}
+	default:
+		doc.Status = types.StatusValid
+	}
+
+	sheetStatus, known := sheetStatusAliases[normalizeHeader(doc.SheetStatus)]
+	doc.StatusMismatch = known && sheetStatus != doc.Status
+}
+
+// daysBetween counts calendar days from the date of from to the date of to,
+// ignoring the time of day.
+func daysBetween(from, to time.Time) int {
+	fromDate := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	toDate := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+	return int(toDate.Sub(fromDate).Hours() / 24)
+}
+
+// parseDays reads a comma separated list of day counts such as "90,30,7,0",
+// used for warning windows and reminder schedules. Negative and malformed
+// entries are dropped; callers decide whether 0 is meaningful.
+func parseDays(value string) []int {
+	var days []int
+	for _, field := range strings.Split(value, ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(field)); err == nil && n >= 0 {
+			days = append(days, n)
+		}
+	}
+	return days
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"lambda/types"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStatusEngineEvaluate(t *testing.T) {
+	now := time.Date(2025, 3, 4, 15, 0, 0, 0, time.UTC)
+	engine := NewStatusEngine(now, &types.SheetSettings{})
+
+	tests := []struct {
+		name         string
+		expiry       time.Time
+		sheet        string
+		wantStatus   string
+		wantDays     int
+		wantWindow   int
+		wantMismatch bool
+	}{
+		{name: "no expiry date", wantStatus: types.StatusUnknown},
+		{name: "expired yesterday", expiry: now.AddDate(0, 0, -1), wantStatus: types.StatusExpired, wantDays: -1},
+		{name: "expires today", expiry: time.Date(2025, 3, 4, 0, 0, 0, 0, time.UTC), wantStatus: types.StatusExpiringSoon, wantWindow: 7},
+		{name: "inside the 30 day window", expiry: now.AddDate(0, 0, 30), wantStatus: types.StatusExpiringSoon, wantDays: 30, wantWindow: 30},
+		{name: "inside the 90 day window", expiry: now.AddDate(0, 0, 31), wantStatus: types.StatusExpiringSoon, wantDays: 31, wantWindow: 90},
+		{name: "beyond every window", expiry: now.AddDate(0, 0, 91), wantStatus: types.StatusValid, wantDays: 91},
+		{name: "sheet status agrees", expiry: now.AddDate(0, 0, 91), sheet: "Active", wantStatus: types.StatusValid, wantDays: 91},
+		{name: "sheet status disagrees", expiry: now.AddDate(0, 0, -3), sheet: "Valid", wantStatus: types.StatusExpired, wantDays: -3, wantMismatch: true},
+		{name: "unknown sheet status is not compared", expiry: now.AddDate(0, 0, -3), sheet: "pending", wantStatus: types.StatusExpired, wantDays: -3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := &types.Document{ExpiryDate: tt.expiry, SheetStatus: tt.sheet}
+			engine.Evaluate([]*types.Document{doc})
+			if doc.Status != tt.wantStatus {
+				t.Errorf("Status = %q, want %q", doc.Status, tt.wantStatus)
+			}
+			if doc.DaysRemaining != tt.wantDays {
+				t.Errorf("DaysRemaining = %d, want %d", doc.DaysRemaining, tt.wantDays)
+			}
+			if doc.WarningWindow != tt.wantWindow {
+				t.Errorf("WarningWindow = %d, want %d", doc.WarningWindow, tt.wantWindow)
+			}
+			if doc.StatusMismatch != tt.wantMismatch {
+				t.Errorf("StatusMismatch = %v, want %v", doc.StatusMismatch, tt.wantMismatch)
+			}
+		})
+	}
+}
+
+func TestNewStatusEngineWarningDays(t *testing.T) {
+	tests := []struct {
+		configured []int
+		want       []int
+	}{
+		{nil, []int{90, 30, 7}},
+		{[]int{14, 60}, []int{60, 14}},
+		{[]int{0, -5}, []int{90, 30, 7}},
+	}
+	for _, tt := range tests {
+		engine := NewStatusEngine(time.Now(), &types.SheetSettings{WarningDays: tt.configured})
+		if !reflect.DeepEqual(engine.WarningDays, tt.want) {
+			t.Errorf("WarningDays for %v = %v, want %v", tt.configured, engine.WarningDays, tt.want)
+		}
+	}
+}
+
+func TestDaysBetweenUsesSheetTimezone(t *testing.T) {
+	// 23:30 UTC on 3 March is already 4 March in Sydney
+	now := time.Date(2025, 3, 3, 23, 30, 0, 0, time.UTC)
+	expiry := time.Date(2025, 3, 5, 0, 0, 0, 0, time.UTC)
+
+	if got := NewStatusEngine(now, &types.SheetSettings{}).Now; daysBetween(got, expiry) != 2 {
+		t.Errorf("days remaining in UTC = %d, want 2", daysBetween(got, expiry))
+	}
+	if got := NewStatusEngine(now, &types.SheetSettings{Timezone: "Australia/Sydney"}).Now; daysBetween(got, expiry) != 1 {
+		t.Errorf("days remaining in Sydney = %d, want 1", daysBetween(got, expiry))
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []int
+	}{
+		{"90,30,7", []int{90, 30, 7}},
+		{" 60 , 14 ", []int{60, 14}},
+		{"90,30,7,1,0", []int{90, 30, 7, 1, 0}},
+		{"30,,abc,-1,7", []int{30, 7}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := parseDays(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseDays(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
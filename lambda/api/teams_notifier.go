@@ -0,0 +1,107 @@
+package api
+
+import (
+	"fmt"
+	"lambda/types"
+	"net/http"
+)
+
+// TeamsNotifier posts Adaptive Cards to a Microsoft Teams incoming webhook or
+// Workflows webhook.
+type TeamsNotifier struct {
+	WebhookURL string
+	Client     *http.Client
+}
+
+func NewTeamsNotifier(webhookURL string) *TeamsNotifier {
+	return &TeamsNotifier{
+		WebhookURL: webhookURL,
+		Client:     notifierHTTPClient,
+	}
+}
+
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string       `json:"contentType"`
+	Content     adaptiveCard `json:"content"`
+}
+
+type adaptiveCard struct {
+	Schema  string                   `json:"$schema"`
+	Type    string                   `json:"type"`
+	Version string                   `json:"version"`
+	Body    []map[string]interface{} `json:"body"`
+}
+
+func (tn *TeamsNotifier) SendDocumentSummary(result *types.SheetResult) error {
+	card := teamsDocumentCard(summaryHeadline(result), result.Documents)
+	if len(result.Issues) > 0 {
+		card.Body = append(card.Body, map[string]interface{}{
+			"type":     "TextBlock",
+			"text":     fmt.Sprintf("%d row(s) could not be tracked.", len(result.Issues)),
+			"isSubtle": true,
+			"wrap":     true,
+		})
+	}
+	return postJSON(tn.Client, tn.WebhookURL, teamsCardMessage(card), nil)
+}
+
+func (tn *TeamsNotifier) SendDocumentReminders(docs []*types.Document) error {
+	card := teamsDocumentCard(remindersHeadline(docs), docs)
+	return postJSON(tn.Client, tn.WebhookURL, teamsCardMessage(card), nil)
+}
+
+// teamsDocumentCard lists documents as facts under a headline.
+func teamsDocumentCard(headline string, documents []*types.Document) adaptiveCard {
+	docs, omitted := chatDocuments(documents)
+
+	var facts []map[string]string
+	for _, doc := range docs {
+		facts = append(facts, map[string]string{
+			"title": doc.DocumentName,
+			"value": fmt.Sprintf("%s, %s", doc.Status, expiryDescription(doc)),
+		})
+	}
+
+	body := []map[string]interface{}{
+		{
+			"type":   "TextBlock",
+			"text":   headline,
+			"size":   "Medium",
+			"weight": "Bolder",
+			"wrap":   true,
+		},
+	}
+	if len(facts) == 0 {
+		body = append(body, map[string]interface{}{"type": "TextBlock", "text": "No documents found.", "wrap": true})
+	} else {
+		body = append(body, map[string]interface{}{"type": "FactSet", "facts": facts})
+	}
+	if omitted > 0 {
+		body = append(body, map[string]interface{}{
+			"type":     "TextBlock",
+			"text":     fmt.Sprintf("…and %d more", omitted),
+			"isSubtle": true,
+		})
+	}
+
+	return adaptiveCard{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: "1.4",
+		Body:    body,
+	}
+}
+
+func teamsCardMessage(card adaptiveCard) *teamsMessage {
+	return &teamsMessage{
+		Type: "message",
+		Attachments: []teamsAttachment{
+			{ContentType: "application/vnd.microsoft.card.adaptive", Content: card},
+		},
+	}
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"lambda/types"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Events sent to generic webhooks.
+const (
+	webhookEventSummary   = "summary"
+	webhookEventReminders = "reminders"
+)
+
+var errWebhookSecretMissing = errors.New("webhook channel has no signing secret")
+
+// WebhookNotifier posts JSON to an arbitrary HTTP endpoint. Every request is
+// signed with the channel's secret so the receiver can verify it came from
+// us: X-DocExpiry-Signature holds "sha256=" followed by the hex HMAC-SHA256
+// of "<X-DocExpiry-Timestamp>.<body>".
+type WebhookNotifier struct {
+	SpreadsheetID string
+	URL           string
+	Secret        string
+	Client        *http.Client
+}
+
+// NewWebhookNotifier fails without a secret rather than sending requests the
+// receiver cannot verify.
+func NewWebhookNotifier(spreadsheetID, url, secret string) (*WebhookNotifier, error) {
+	if secret == "" {
+		return nil, errWebhookSecretMissing
+	}
+	return &WebhookNotifier{
+		SpreadsheetID: spreadsheetID,
+		URL:           url,
+		Secret:        secret,
+		Client:        notifierHTTPClient,
+	}, nil
+}
+
+// webhookPayload is the body of every generic webhook request.
+type webhookPayload struct {
+	Event         string            `json:"event"` // webhookEventSummary or webhookEventReminders
+	SpreadsheetID string            `json:"spreadsheet_id"`
+	Title         string            `json:"title,omitempty"`
+	SentAt        time.Time         `json:"sent_at"`
+	Documents     []*types.Document `json:"documents"`
+	Issues        []types.RowIssue  `json:"issues,omitempty"`
+}
+
+func (wn *WebhookNotifier) SendDocumentSummary(result *types.SheetResult) error {
+	return wn.post(&webhookPayload{
+		Event:         webhookEventSummary,
+		SpreadsheetID: wn.SpreadsheetID,
+		Title:         result.Title,
+		Documents:     result.Documents,
+		Issues:        result.Issues,
+	})
+}
+
+func (wn *WebhookNotifier) SendDocumentReminders(docs []*types.Document) error {
+	return wn.post(&webhookPayload{
+		Event:         webhookEventReminders,
+		SpreadsheetID: wn.SpreadsheetID,
+		Documents:     docs,
+	})
+}
+
+func (wn *WebhookNotifier) post(payload *webhookPayload) error {
+	now := time.Now()
+	payload.SentAt = now.UTC()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error encoding webhook payload: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	if wn.Secret == "" {
+		return errWebhookSecretMissing
+	}
+	headers := map[string]string{
+		"X-DocExpiry-Event":     payload.Event,
+		"X-DocExpiry-Timestamp": timestamp,
+		"X-DocExpiry-Signature": "sha256=" + signWebhook(wn.Secret, timestamp, body),
+	}
+	return postBody(wn.Client, wn.URL, body, headers)
+}
+
+// signWebhook returns the hex HMAC-SHA256 of the timestamp and body. Including
+// the timestamp lets receivers reject replayed requests.
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
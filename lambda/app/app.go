@@ -1,19 +1,61 @@
 package app
 
 import (
+	"fmt"
 	"lambda/api"
+	"lambda/api/auth"
 	"lambda/database"
+	"lambda/middleware"
+	"os"
 )
 
 type Application struct {
 	LoginHandler    *api.LoginHandler
 	CallbackHandler *api.CallBackHandler
+	Scheduler       *api.Scheduler
+	CalendarFeed    *api.CalendarFeedHandler
+	Documents       *api.DocumentsHandler
+	Account         *api.AccountHandler
+	Settings        *api.SettingsHandler
+	TokenMiddleware *middleware.TokenMiddleware
 }
 
 func NewApplication() (*Application, error) {
 	db := database.NewDynamoDBStore()
-	callbackHandler := api.NewCallbackHandler(db)
+	if db.Keys == nil {
+		return nil, database.ErrNoTokenKey
+	}
+
+	authConfig, err := auth.LoadAuthConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error loading OAuth configuration: %w", err)
+	}
+
+	// Only the API signs dashboard sessions, not the scheduled check
+	var sessions *auth.SessionSigner
+	if os.Getenv("HANDLER_MODE") != "scheduler" {
+		sessions, err = auth.SessionSignerFromEnv()
+		if err != nil {
+			return nil, fmt.Errorf("error loading session signing key: %w", err)
+		}
+	}
+
+	loginHandler := api.NewLoginHandler(db, authConfig)
+	callbackHandler := api.NewCallbackHandler(db, authConfig, sessions)
+	scheduler := api.NewScheduler(db, authConfig)
+	calendarFeed := api.NewCalendarFeedHandler(db)
+	documents := api.NewDocumentsHandler(db)
+	account := api.NewAccountHandler(db)
+	settings := api.NewSettingsHandler(db)
+	tokenMiddleware := middleware.NewTokenMiddleware(db, authConfig, sessions)
 	return &Application{
+		LoginHandler:    loginHandler,
 		CallbackHandler: callbackHandler,
+		Scheduler:       scheduler,
+		CalendarFeed:    calendarFeed,
+		Documents:       documents,
+		Account:         account,
+		Settings:        settings,
+		TokenMiddleware: tokenMiddleware,
 	}, nil
 }
@@ -0,0 +1,202 @@
+package database
+
+import (
+	"fmt"
+	"lambda/types"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+const (
+	DOCUMENT_TABLE_NAME         = "Document"
+	DOCUMENT_HISTORY_TABLE_NAME = "DocumentHistory"
+)
+
+// DynamoDB accepts at most 25 writes per batch.
+const maxBatchWrite = 25
+
+// Sortable timestamp used in history sort keys.
+const historyTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// ListDocuments returns every stored document of a spreadsheet, including
+// documents that were deleted from it.
+func (db *DynamoDBStore) ListDocuments(userID, spreadsheetID string) ([]*types.StoredDocument, error) {
+	var docs []*types.StoredDocument
+	var decodeErr error
+	err := db.DB.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(DOCUMENT_TABLE_NAME),
+		KeyConditionExpression: aws.String("sheet_key = :key"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key": {S: aws.String(sheetKey(userID, spreadsheetID))},
+		},
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var doc types.StoredDocument
+			if err := dynamodbattribute.UnmarshalMap(item, &doc); err != nil {
+				decodeErr = fmt.Errorf("error decoding document: %w", err)
+				return false
+			}
+			docs = append(docs, &doc)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing documents: %w", err)
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	return docs, nil
+}
+
+// PutDocuments inserts or replaces stored documents, keyed by spreadsheet
+// and document ID.
+func (db *DynamoDBStore) PutDocuments(docs []*types.StoredDocument) error {
+	var requests []*dynamodb.WriteRequest
+	for _, doc := range docs {
+		item, err := dynamodbattribute.MarshalMap(doc)
+		if err != nil {
+			return fmt.Errorf("error encoding document: %w", err)
+		}
+		item["sheet_key"] = &dynamodb.AttributeValue{S: aws.String(sheetKey(doc.UserID, doc.SpreadsheetID))}
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+	}
+	if err := db.batchWrite(DOCUMENT_TABLE_NAME, requests); err != nil {
+		return fmt.Errorf("error storing documents: %w", err)
+	}
+	return nil
+}
+
+// AddDocumentChanges appends entries to the history of documents.
+func (db *DynamoDBStore) AddDocumentChanges(changes []*types.DocumentChange) error {
+	var requests []*dynamodb.WriteRequest
+	for _, change := range changes {
+		item, err := dynamodbattribute.MarshalMap(change)
+		if err != nil {
+			return fmt.Errorf("error encoding document change: %w", err)
+		}
+		item["document_key"] = &dynamodb.AttributeValue{S: aws.String(documentKey(change.UserID, change.SpreadsheetID, change.DocumentID))}
+		// Several changes of one document can share a timestamp
+		item["change_key"] = &dynamodb.AttributeValue{S: aws.String(change.ChangedAt.UTC().Format(historyTimeLayout) + "#" + change.Change)}
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+	}
+	if err := db.batchWrite(DOCUMENT_HISTORY_TABLE_NAME, requests); err != nil {
+		return fmt.Errorf("error storing document history: %w", err)
+	}
+	return nil
+}
+
+// DocumentHistory returns the changes of a document, oldest first.
+func (db *DynamoDBStore) DocumentHistory(userID, spreadsheetID, documentID string) ([]*types.DocumentChange, error) {
+	var changes []*types.DocumentChange
+	var decodeErr error
+	err := db.DB.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(DOCUMENT_HISTORY_TABLE_NAME),
+		KeyConditionExpression: aws.String("document_key = :key"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":key": {S: aws.String(documentKey(userID, spreadsheetID, documentID))},
+		},
+		ScanIndexForward: aws.Bool(true),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var change types.DocumentChange
+			if err := dynamodbattribute.UnmarshalMap(item, &change); err != nil {
+				decodeErr = fmt.Errorf("error decoding document change: %w", err)
+				return false
+			}
+			changes = append(changes, &change)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching document history: %w", err)
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	return changes, nil
+}
+
+// DeleteDocuments removes the stored documents of a spreadsheet together with
+// their history.
+func (db *DynamoDBStore) DeleteDocuments(userID, spreadsheetID string) error {
+	docs, err := db.ListDocuments(userID, spreadsheetID)
+	if err != nil {
+		return err
+	}
+
+	var docRequests, historyRequests []*dynamodb.WriteRequest
+	for _, doc := range docs {
+		key := documentKey(userID, spreadsheetID, doc.ID)
+		err := db.DB.QueryPages(&dynamodb.QueryInput{
+			TableName:              aws.String(DOCUMENT_HISTORY_TABLE_NAME),
+			KeyConditionExpression: aws.String("document_key = :key"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":key": {S: aws.String(key)},
+			},
+			ProjectionExpression: aws.String("document_key, change_key"),
+		}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, item := range page.Items {
+				historyRequests = append(historyRequests, &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{Key: item}})
+			}
+			return true
+		})
+		if err != nil {
+			return fmt.Errorf("error fetching document history: %w", err)
+		}
+
+		docRequests = append(docRequests, &dynamodb.WriteRequest{DeleteRequest: &dynamodb.DeleteRequest{
+			Key: map[string]*dynamodb.AttributeValue{
+				"sheet_key": {S: aws.String(sheetKey(userID, spreadsheetID))},
+				"id":        {S: aws.String(doc.ID)},
+			},
+		}})
+	}
+
+	// History first, so a failure leaves the documents to retry from
+	if err := db.batchWrite(DOCUMENT_HISTORY_TABLE_NAME, historyRequests); err != nil {
+		return fmt.Errorf("error deleting document history: %w", err)
+	}
+	if err := db.batchWrite(DOCUMENT_TABLE_NAME, docRequests); err != nil {
+		return fmt.Errorf("error deleting documents: %w", err)
+	}
+	return nil
+}
+
+// batchWrite writes requests in batches, retrying unprocessed items with a
+// short backoff.
+func (db *DynamoDBStore) batchWrite(tableName string, requests []*dynamodb.WriteRequest) error {
+	for start := 0; start < len(requests); start += maxBatchWrite {
+		end := start + maxBatchWrite
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		pending := map[string][]*dynamodb.WriteRequest{tableName: requests[start:end]}
+		for attempt := 0; len(pending[tableName]) > 0; attempt++ {
+			if attempt > 0 {
+				if attempt > 5 {
+					return fmt.Errorf("%d writes to %s were not processed", len(pending[tableName]), tableName)
+				}
+				time.Sleep(time.Duration(attempt*attempt) * 50 * time.Millisecond)
+			}
+			result, err := db.DB.BatchWriteItem(&dynamodb.BatchWriteItemInput{RequestItems: pending})
+			if err != nil {
+				return err
+			}
+			pending = result.UnprocessedItems
+		}
+	}
+	return nil
+}
+
+func sheetKey(userID, spreadsheetID string) string {
+	return userID + "#" + spreadsheetID
+}
+
+func documentKey(userID, spreadsheetID, documentID string) string {
+	return userID + "#" + spreadsheetID + "#" + documentID
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	FEED_TABLE_NAME       = "CalendarFeed"
+	FEED_TOKEN_INDEX_NAME = "feed_token-index"
+)
+
+var ErrFeedNotFound = errors.New("calendar feed not found")
+
+// FeedToken returns the calendar feed token of a user, creating one the
+// first time. The token is the only credential needed to read the feed, so
+// it is long and random.
+func (db *DynamoDBStore) FeedToken(userID string) (string, error) {
+	result, err := db.DB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(FEED_TABLE_NAME),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userID)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error fetching calendar feed: %w", err)
+	}
+	if token := stringAttr(result.Item, "feed_token"); token != "" {
+		return token, nil
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("error generating feed token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	_, err = db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(FEED_TABLE_NAME),
+		Item: map[string]*dynamodb.AttributeValue{
+			"user_id":    {S: aws.String(userID)},
+			"feed_token": {S: aws.String(token)},
+			"created_at": {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(user_id)"),
+	})
+	if isConditionalCheckFailed(err) {
+		// Created concurrently; use the stored token
+		return db.FeedToken(userID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error storing feed token: %w", err)
+	}
+	return token, nil
+}
+
+// FeedUser returns the user a calendar feed token belongs to. It fails with
+// ErrFeedNotFound for unknown tokens.
+func (db *DynamoDBStore) FeedUser(feedToken string) (string, error) {
+	if feedToken == "" {
+		return "", ErrFeedNotFound
+	}
+	result, err := db.DB.Query(&dynamodb.QueryInput{
+		TableName:              aws.String(FEED_TABLE_NAME),
+		IndexName:              aws.String(FEED_TOKEN_INDEX_NAME),
+		KeyConditionExpression: aws.String("feed_token = :token"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":token": {S: aws.String(feedToken)},
+		},
+		Limit: aws.Int64(1),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error looking up calendar feed: %w", err)
+	}
+	if len(result.Items) == 0 {
+		return "", ErrFeedNotFound
+	}
+	return stringAttr(result.Items[0], "user_id"), nil
+}
+
+// DeleteFeed removes the calendar feed of a user, so its token stops
+// working. A later FeedToken call creates a new token.
+func (db *DynamoDBStore) DeleteFeed(userID string) error {
+	_, err := db.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(FEED_TABLE_NAME),
+		Key: map[string]*dynamodb.AttributeValue{
+			"user_id": {S: aws.String(userID)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting calendar feed: %w", err)
+	}
+	return nil
+}
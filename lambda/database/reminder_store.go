@@ -0,0 +1,49 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const REMINDER_TABLE_NAME = "Reminder"
+
+// RecordReminder marks a notification as sent. It returns false without
+// error when the notification was already recorded, which lets concurrent or
+// repeated runs claim each notification exactly once. The record is removed
+// by DynamoDB TTL after expiresAt.
+func (db *DynamoDBStore) RecordReminder(key string, expiresAt time.Time) (bool, error) {
+	_, err := db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(REMINDER_TABLE_NAME),
+		Item: map[string]*dynamodb.AttributeValue{
+			"reminder_key": {S: aws.String(key)},
+			"sent_at":      {S: aws.String(time.Now().UTC().Format(time.RFC3339))},
+			"expires_at":   {N: aws.String(fmt.Sprintf("%d", expiresAt.Unix()))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(reminder_key)"),
+	})
+	if isConditionalCheckFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error recording reminder: %w", err)
+	}
+	return true, nil
+}
+
+// DeleteReminder forgets a recorded notification so it is sent again on
+// the next run.
+func (db *DynamoDBStore) DeleteReminder(key string) error {
+	_, err := db.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(REMINDER_TABLE_NAME),
+		Key: map[string]*dynamodb.AttributeValue{
+			"reminder_key": {S: aws.String(key)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting reminder: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"lambda/types"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const RESULT_TABLE_NAME = "CheckResult"
+
+// SaveResult replaces the cached result of a spreadsheet. The documents are
+// stored as compressed JSON so large sheets stay within DynamoDB's item size
+// limit.
+func (db *DynamoDBStore) SaveResult(result *types.CheckResult) error {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if err := json.NewEncoder(writer).Encode(result); err != nil {
+		return fmt.Errorf("error encoding result: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("error compressing result: %w", err)
+	}
+
+	_, err := db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(RESULT_TABLE_NAME),
+		Item: map[string]*dynamodb.AttributeValue{
+			"user_id":        {S: aws.String(result.UserID)},
+			"spreadsheet_id": {S: aws.String(result.SpreadsheetID)},
+			"checked_at":     {S: aws.String(result.CheckedAt.UTC().Format(time.RFC3339))},
+			"result":         {B: compressed.Bytes()},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error saving result: %w", err)
+	}
+	return nil
+}
+
+// ListResults returns the cached results of every spreadsheet of a user.
+func (db *DynamoDBStore) ListResults(userID string) ([]*types.CheckResult, error) {
+	var results []*types.CheckResult
+	var decodeErr error
+	err := db.DB.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(RESULT_TABLE_NAME),
+		KeyConditionExpression: aws.String("user_id = :uid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":uid": {S: aws.String(userID)},
+		},
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			result, err := resultFromItem(item)
+			if err != nil {
+				decodeErr = err
+				return false
+			}
+			results = append(results, result)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing results: %w", err)
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	return results, nil
+}
+
+// DeleteResult forgets the cached result of a spreadsheet.
+func (db *DynamoDBStore) DeleteResult(userID, spreadsheetID string) error {
+	_, err := db.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(RESULT_TABLE_NAME),
+		Key:       spreadsheetKey(userID, spreadsheetID),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting result: %w", err)
+	}
+	return nil
+}
+
+func resultFromItem(item map[string]*dynamodb.AttributeValue) (*types.CheckResult, error) {
+	value, ok := item["result"]
+	if !ok || value.B == nil {
+		return nil, fmt.Errorf("result of spreadsheet %s is empty", stringAttr(item, "spreadsheet_id"))
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(value.B))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing result: %w", err)
+	}
+	defer reader.Close()
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing result: %w", err)
+	}
+
+	var result types.CheckResult
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("error decoding result: %w", err)
+	}
+	return &result, nil
+}
@@ -1 +1,69 @@
-package database
\ No newline at end of file
+package database
+
+import (
+	"errors"
+	"fmt"
+	"lambda/types"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+const SESSION_TABLE_NAME = "OAuthSession"
+
+var ErrSessionNotFound = errors.New("sign in session not found or expired")
+
+// CreateOAuthSession records a sign in started at /login. Nonces are random,
+// so an existing record means the nonce was reused and is rejected.
+func (db *DynamoDBStore) CreateOAuthSession(session *types.OAuthSession) error {
+	item, err := dynamodbattribute.MarshalMap(session)
+	if err != nil {
+		return fmt.Errorf("error encoding sign in session: %w", err)
+	}
+	_, err = db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(SESSION_TABLE_NAME),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(nonce)"),
+	})
+	if err != nil {
+		return fmt.Errorf("error storing sign in session: %w", err)
+	}
+	return nil
+}
+
+// ConsumeOAuthSession deletes and returns the sign in session of a nonce.
+// The delete is conditional, so each session is consumed exactly once even
+// by concurrent callbacks. It fails with ErrSessionNotFound for unknown,
+// already used and expired nonces; TTL removes expired records only
+// eventually, so expiry is checked in the condition as well.
+func (db *DynamoDBStore) ConsumeOAuthSession(nonce string, now time.Time) (*types.OAuthSession, error) {
+	if nonce == "" {
+		return nil, ErrSessionNotFound
+	}
+	result, err := db.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(SESSION_TABLE_NAME),
+		Key: map[string]*dynamodb.AttributeValue{
+			"nonce": {S: aws.String(nonce)},
+		},
+		ConditionExpression: aws.String("attribute_exists(nonce) AND expires_at > :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if isConditionalCheckFailed(err) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error consuming sign in session: %w", err)
+	}
+
+	var session types.OAuthSession
+	if err := dynamodbattribute.UnmarshalMap(result.Attributes, &session); err != nil {
+		return nil, fmt.Errorf("error decoding sign in session: %w", err)
+	}
+	return &session, nil
+}
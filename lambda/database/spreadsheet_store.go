@@ -0,0 +1,233 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"lambda/types"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+const SPREADSHEET_TABLE_NAME = "Spreadsheet"
+
+var (
+	ErrSpreadsheetExists   = errors.New("spreadsheet already registered")
+	ErrSpreadsheetNotFound = errors.New("spreadsheet not found")
+)
+
+// CreateSpreadsheet registers a spreadsheet for a user. It fails with
+// ErrSpreadsheetExists when the user already registered it.
+func (db *DynamoDBStore) CreateSpreadsheet(sheet *types.Spreadsheet) error {
+	now := time.Now().UTC()
+	sheet.CreatedAt = now
+	sheet.UpdatedAt = now
+
+	item, err := db.encodeSpreadsheet(sheet)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(SPREADSHEET_TABLE_NAME),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(spreadsheet_id)"),
+	})
+	if isConditionalCheckFailed(err) {
+		return ErrSpreadsheetExists
+	}
+	if err != nil {
+		return fmt.Errorf("error inserting spreadsheet into database: %w", err)
+	}
+	return nil
+}
+
+// GetSpreadsheet returns a single registered spreadsheet.
+func (db *DynamoDBStore) GetSpreadsheet(userID, spreadsheetID string) (*types.Spreadsheet, error) {
+	result, err := db.DB.GetItem(&dynamodb.GetItemInput{
+		TableName: aws.String(SPREADSHEET_TABLE_NAME),
+		Key:       spreadsheetKey(userID, spreadsheetID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching spreadsheet: %w", err)
+	}
+	if len(result.Item) == 0 {
+		return nil, ErrSpreadsheetNotFound
+	}
+
+	return db.decodeSpreadsheet(result.Item)
+}
+
+// ListSpreadsheets returns every spreadsheet registered by a user.
+func (db *DynamoDBStore) ListSpreadsheets(userID string) ([]*types.Spreadsheet, error) {
+	var sheets []*types.Spreadsheet
+	var decodeErr error
+	err := db.DB.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(SPREADSHEET_TABLE_NAME),
+		KeyConditionExpression: aws.String("user_id = :uid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":uid": {S: aws.String(userID)},
+		},
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			sheet, err := db.decodeSpreadsheet(item)
+			if err != nil {
+				decodeErr = err
+				return false
+			}
+			sheets = append(sheets, sheet)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing spreadsheets: %w", err)
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	return sheets, nil
+}
+
+// UpdateSpreadsheet replaces the settings of a registered spreadsheet while
+// keeping its creation time. It fails with ErrSpreadsheetNotFound when the
+// spreadsheet was never registered.
+func (db *DynamoDBStore) UpdateSpreadsheet(sheet *types.Spreadsheet) error {
+	if sheet.CreatedAt.IsZero() {
+		existing, err := db.GetSpreadsheet(sheet.UserID, sheet.SpreadsheetID)
+		if err != nil {
+			return err
+		}
+		sheet.CreatedAt = existing.CreatedAt
+	}
+	sheet.UpdatedAt = time.Now().UTC()
+
+	item, err := db.encodeSpreadsheet(sheet)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.DB.PutItem(&dynamodb.PutItemInput{
+		TableName:           aws.String(SPREADSHEET_TABLE_NAME),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_exists(spreadsheet_id)"),
+	})
+	if isConditionalCheckFailed(err) {
+		return ErrSpreadsheetNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error updating spreadsheet: %w", err)
+	}
+	return nil
+}
+
+// DeleteSpreadsheet removes a registered spreadsheet.
+func (db *DynamoDBStore) DeleteSpreadsheet(userID, spreadsheetID string) error {
+	_, err := db.DB.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(SPREADSHEET_TABLE_NAME),
+		Key:       spreadsheetKey(userID, spreadsheetID),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting spreadsheet: %w", err)
+	}
+	return nil
+}
+
+// encodeSpreadsheet marshals a spreadsheet for storage. The webhook URLs and
+// signing secrets of its notification channels are credentials, so they are
+// encrypted with a data key of their own, stored as channel_key.
+func (db *DynamoDBStore) encodeSpreadsheet(sheet *types.Spreadsheet) (map[string]*dynamodb.AttributeValue, error) {
+	stored := *sheet
+	var dataKey []byte
+	if len(sheet.Notifications.Channels) > 0 {
+		channelCipher, encryptedKey, err := db.newRecordCipher(spreadsheetRecordID(sheet), channelKeyContext(sheet.UserID))
+		if err != nil {
+			return nil, fmt.Errorf("error encrypting notification channels: %w", err)
+		}
+		channels := make([]types.NotificationChannel, len(sheet.Notifications.Channels))
+		for i, channel := range sheet.Notifications.Channels {
+			if channel.URL, err = channelCipher.encrypt(channelField(i, "url"), channel.URL); err != nil {
+				return nil, fmt.Errorf("error encrypting notification channels: %w", err)
+			}
+			if channel.Secret != "" {
+				if channel.Secret, err = channelCipher.encrypt(channelField(i, "secret"), channel.Secret); err != nil {
+					return nil, fmt.Errorf("error encrypting notification channels: %w", err)
+				}
+			}
+			channels[i] = channel
+		}
+		stored.Notifications.Channels = channels
+		dataKey = encryptedKey
+	}
+
+	item, err := dynamodbattribute.MarshalMap(&stored)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding spreadsheet: %w", err)
+	}
+	if dataKey != nil {
+		item["channel_key"] = &dynamodb.AttributeValue{B: dataKey}
+	}
+	return item, nil
+}
+
+// decodeSpreadsheet unmarshals a stored spreadsheet, decrypting its
+// notification channels. Channels saved before they were encrypted are read
+// as they are.
+func (db *DynamoDBStore) decodeSpreadsheet(item map[string]*dynamodb.AttributeValue) (*types.Spreadsheet, error) {
+	var sheet types.Spreadsheet
+	if err := dynamodbattribute.UnmarshalMap(item, &sheet); err != nil {
+		return nil, fmt.Errorf("error decoding spreadsheet: %w", err)
+	}
+
+	dataKey, ok := item["channel_key"]
+	if !ok || len(dataKey.B) == 0 {
+		return &sheet, nil
+	}
+	channelCipher, err := db.openRecordCipher(spreadsheetRecordID(&sheet), channelKeyContext(sheet.UserID), dataKey.B)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting notification channels: %w", err)
+	}
+	for i := range sheet.Notifications.Channels {
+		channel := &sheet.Notifications.Channels[i]
+		if channel.URL, err = channelCipher.decrypt(channelField(i, "url"), channel.URL); err != nil {
+			return nil, err
+		}
+		if channel.Secret != "" {
+			if channel.Secret, err = channelCipher.decrypt(channelField(i, "secret"), channel.Secret); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &sheet, nil
+}
+
+func spreadsheetRecordID(sheet *types.Spreadsheet) string {
+	return sheet.UserID + "#" + sheet.SpreadsheetID
+}
+
+func channelField(index int, name string) string {
+	return fmt.Sprintf("channels.%d.%s", index, name)
+}
+
+func channelKeyContext(userID string) map[string]string {
+	return map[string]string{
+		"table":   SPREADSHEET_TABLE_NAME,
+		"user_id": userID,
+	}
+}
+
+func spreadsheetKey(userID, spreadsheetID string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"user_id":        {S: aws.String(userID)},
+		"spreadsheet_id": {S: aws.String(spreadsheetID)},
+	}
+}
+
+// isConditionalCheckFailed reports whether a write was rejected by its
+// condition expression.
+func isConditionalCheckFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
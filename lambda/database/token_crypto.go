@@ -0,0 +1,247 @@
+package database
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+)
+
+// Token secrets are envelope encrypted: each token record has its own data
+// key, stored encrypted by a KeyProvider next to the fields it encrypts.
+// Ciphertexts are prefixed with a version so the format can change.
+const encryptedFieldPrefix = "v1:"
+
+const dataKeySize = 32 // AES-256
+
+var ErrNoTokenKey = errors.New("no token encryption key configured; set TOKEN_KMS_KEY_ID")
+
+// KeyProvider generates data keys and decrypts them again. The encryption
+// context is bound to the key, so a data key only decrypts for the record it
+// was generated for.
+type KeyProvider interface {
+	GenerateDataKey(encryptionContext map[string]string) (plaintext, encrypted []byte, err error)
+	DecryptDataKey(encrypted []byte, encryptionContext map[string]string) ([]byte, error)
+}
+
+// KMSKeyProvider generates data keys under a KMS key.
+type KMSKeyProvider struct {
+	client *kms.KMS
+	keyID  string
+}
+
+func NewKMSKeyProvider(client *kms.KMS, keyID string) *KMSKeyProvider {
+	return &KMSKeyProvider{
+		client: client,
+		keyID:  keyID,
+	}
+}
+
+func (kp *KMSKeyProvider) GenerateDataKey(encryptionContext map[string]string) ([]byte, []byte, error) {
+	output, err := kp.client.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:             aws.String(kp.keyID),
+		KeySpec:           aws.String(kms.DataKeySpecAes256),
+		EncryptionContext: aws.StringMap(encryptionContext),
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error generating data key: %w", err)
+	}
+	return output.Plaintext, output.CiphertextBlob, nil
+}
+
+func (kp *KMSKeyProvider) DecryptDataKey(encrypted []byte, encryptionContext map[string]string) ([]byte, error) {
+	output, err := kp.client.Decrypt(&kms.DecryptInput{
+		KeyId:             aws.String(kp.keyID),
+		CiphertextBlob:    encrypted,
+		EncryptionContext: aws.StringMap(encryptionContext),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting data key: %w", err)
+	}
+	return output.Plaintext, nil
+}
+
+// LocalKeyProvider wraps data keys with a static AES key instead of KMS, for
+// tests and local development.
+type LocalKeyProvider struct {
+	aead cipher.AEAD
+}
+
+func NewLocalKeyProvider(key []byte) (*LocalKeyProvider, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalKeyProvider{aead: aead}, nil
+}
+
+func (kp *LocalKeyProvider) GenerateDataKey(encryptionContext map[string]string) ([]byte, []byte, error) {
+	plaintext := make([]byte, dataKeySize)
+	if _, err := rand.Read(plaintext); err != nil {
+		return nil, nil, fmt.Errorf("error generating data key: %w", err)
+	}
+	encrypted, err := seal(kp.aead, plaintext, contextAAD(encryptionContext))
+	if err != nil {
+		return nil, nil, err
+	}
+	return plaintext, encrypted, nil
+}
+
+func (kp *LocalKeyProvider) DecryptDataKey(encrypted []byte, encryptionContext map[string]string) ([]byte, error) {
+	plaintext, err := unseal(kp.aead, encrypted, contextAAD(encryptionContext))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting data key: %w", err)
+	}
+	return plaintext, nil
+}
+
+// keyProviderFromEnv uses the KMS key in TOKEN_KMS_KEY_ID or, for local
+// development, the base64 encoded AES-256 key in TOKEN_LOCAL_KEY. It returns
+// nil when neither is set.
+func keyProviderFromEnv(sess *session.Session) (KeyProvider, error) {
+	if keyID := strings.TrimSpace(os.Getenv("TOKEN_KMS_KEY_ID")); keyID != "" {
+		return NewKMSKeyProvider(kms.New(sess), keyID), nil
+	}
+	if encoded := strings.TrimSpace(os.Getenv("TOKEN_LOCAL_KEY")); encoded != "" {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("TOKEN_LOCAL_KEY is not valid base64: %w", err)
+		}
+		return NewLocalKeyProvider(key)
+	}
+	return nil, nil
+}
+
+// tokenCipher encrypts the secret fields of one record, such as a token, with
+// the record's data key. Each field is bound to the record and field name, so
+// ciphertexts cannot be swapped between records or fields.
+type tokenCipher struct {
+	aead     cipher.AEAD
+	recordID string
+}
+
+// newTokenCipher generates a data key for a token record, returning the
+// cipher and the encrypted key to store with the record.
+func (db *DynamoDBStore) newTokenCipher(recordID, userID string) (*tokenCipher, []byte, error) {
+	return db.newRecordCipher(recordID, tokenKeyContext(userID))
+}
+
+// openTokenCipher decrypts the data key stored with a token record.
+func (db *DynamoDBStore) openTokenCipher(recordID, userID string, encryptedKey []byte) (*tokenCipher, error) {
+	return db.openRecordCipher(recordID, tokenKeyContext(userID), encryptedKey)
+}
+
+// newRecordCipher generates a data key bound to the encryption context of a
+// record, returning the cipher and the encrypted key to store with it.
+func (db *DynamoDBStore) newRecordCipher(recordID string, keyContext map[string]string) (*tokenCipher, []byte, error) {
+	if db.Keys == nil {
+		return nil, nil, ErrNoTokenKey
+	}
+	plaintext, encrypted, err := db.Keys.GenerateDataKey(keyContext)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(plaintext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &tokenCipher{aead: aead, recordID: recordID}, encrypted, nil
+}
+
+// openRecordCipher decrypts the data key stored with a record.
+func (db *DynamoDBStore) openRecordCipher(recordID string, keyContext map[string]string, encryptedKey []byte) (*tokenCipher, error) {
+	if db.Keys == nil {
+		return nil, ErrNoTokenKey
+	}
+	plaintext, err := db.Keys.DecryptDataKey(encryptedKey, keyContext)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenCipher{aead: aead, recordID: recordID}, nil
+}
+
+func (tc *tokenCipher) encrypt(field, value string) (string, error) {
+	sealed, err := seal(tc.aead, []byte(value), []byte(tc.recordID+"#"+field))
+	if err != nil {
+		return "", err
+	}
+	return encryptedFieldPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (tc *tokenCipher) decrypt(field, value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedFieldPrefix) {
+		return "", fmt.Errorf("%s of record %s is not encrypted", field, tc.recordID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedFieldPrefix))
+	if err != nil {
+		return "", fmt.Errorf("error decoding %s of record %s: %w", field, tc.recordID, err)
+	}
+	plaintext, err := unseal(tc.aead, sealed, []byte(tc.recordID+"#"+field))
+	if err != nil {
+		return "", fmt.Errorf("error decrypting %s of record %s: %w", field, tc.recordID, err)
+	}
+	return string(plaintext), nil
+}
+
+func tokenKeyContext(userID string) map[string]string {
+	return map[string]string{
+		"table":   TABLE_NAME,
+		"user_id": userID,
+	}
+}
+
+// contextAAD serializes an encryption context in a stable order.
+func contextAAD(encryptionContext map[string]string) []byte {
+	keys := make([]string, 0, len(encryptionContext))
+	for key := range encryptionContext {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var aad strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&aad, "%d:%s=%d:%s;", len(key), key, len(encryptionContext[key]), encryptionContext[key])
+	}
+	return []byte(aad.String())
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != dataKeySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", dataKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts with a random nonce, which is prepended to the ciphertext.
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func unseal(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
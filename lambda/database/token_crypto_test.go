@@ -0,0 +1,254 @@
+package database
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func newTestKeyProvider(t *testing.T) *LocalKeyProvider {
+	t.Helper()
+	provider, err := NewLocalKeyProvider(bytes.Repeat([]byte{7}, dataKeySize))
+	if err != nil {
+		t.Fatalf("NewLocalKeyProvider: %v", err)
+	}
+	return provider
+}
+
+func TestLocalKeyProviderRoundTrip(t *testing.T) {
+	provider := newTestKeyProvider(t)
+	keyContext := tokenKeyContext("user-1")
+
+	plaintext, encrypted, err := provider.GenerateDataKey(keyContext)
+	if err != nil {
+		t.Fatalf("GenerateDataKey: %v", err)
+	}
+	if len(plaintext) != dataKeySize || bytes.Contains(encrypted, plaintext) {
+		t.Fatalf("data key is %d bytes or stored in the clear", len(plaintext))
+	}
+
+	decrypted, err := provider.DecryptDataKey(encrypted, keyContext)
+	if err != nil {
+		t.Fatalf("DecryptDataKey: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("DecryptDataKey returned a different key")
+	}
+
+	if _, err := provider.DecryptDataKey(encrypted, tokenKeyContext("user-2")); err == nil {
+		t.Errorf("data key decrypted under another user's context")
+	}
+	other, err := NewLocalKeyProvider(bytes.Repeat([]byte{8}, dataKeySize))
+	if err != nil {
+		t.Fatalf("NewLocalKeyProvider: %v", err)
+	}
+	if _, err := other.DecryptDataKey(encrypted, keyContext); err == nil {
+		t.Errorf("data key decrypted with another master key")
+	}
+}
+
+func TestNewLocalKeyProviderRejectsShortKeys(t *testing.T) {
+	if _, err := NewLocalKeyProvider([]byte("too short")); err == nil {
+		t.Error("NewLocalKeyProvider accepted a 9 byte key")
+	}
+}
+
+func TestTokenCipher(t *testing.T) {
+	db := &DynamoDBStore{Keys: newTestKeyProvider(t)}
+	encrypter, dataKey, err := db.newTokenCipher("token-1", "user-1")
+	if err != nil {
+		t.Fatalf("newTokenCipher: %v", err)
+	}
+	ciphertext, err := encrypter.encrypt("AccessToken", "ya29.secret")
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+	if !strings.HasPrefix(ciphertext, encryptedFieldPrefix) || strings.Contains(ciphertext, "ya29.secret") {
+		t.Fatalf("ciphertext = %q", ciphertext)
+	}
+
+	// Flip one bit of the sealed value
+	sealed, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(ciphertext, encryptedFieldPrefix))
+	sealed[len(sealed)-1] ^= 1
+	tampered := encryptedFieldPrefix + base64.StdEncoding.EncodeToString(sealed)
+
+	tests := []struct {
+		name     string
+		recordID string
+		userID   string
+		field    string
+		value    string
+		want     string
+		wantErr  bool
+	}{
+		{name: "round trip", recordID: "token-1", userID: "user-1", field: "AccessToken", value: ciphertext, want: "ya29.secret"},
+		{name: "tampered ciphertext", recordID: "token-1", userID: "user-1", field: "AccessToken", value: tampered, wantErr: true},
+		{name: "swapped field", recordID: "token-1", userID: "user-1", field: "RefreshToken", value: ciphertext, wantErr: true},
+		{name: "copied to another record", recordID: "token-2", userID: "user-1", field: "AccessToken", value: ciphertext, wantErr: true},
+		{name: "not encrypted", recordID: "token-1", userID: "user-1", field: "AccessToken", value: "ya29.secret", wantErr: true},
+		{name: "not base64", recordID: "token-1", userID: "user-1", field: "AccessToken", value: encryptedFieldPrefix + "%%%", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decrypter, err := db.openTokenCipher(tt.recordID, tt.userID, dataKey)
+			if err != nil {
+				t.Fatalf("openTokenCipher: %v", err)
+			}
+			got, err := decrypter.decrypt(tt.field, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("decrypt = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("decrypt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := db.openTokenCipher("token-1", "user-2", dataKey); err == nil {
+		t.Error("data key opened for another user")
+	}
+}
+
+func TestTokenCipherWithoutKey(t *testing.T) {
+	db := &DynamoDBStore{}
+	if _, _, err := db.newTokenCipher("token-1", "user-1"); err != ErrNoTokenKey {
+		t.Errorf("newTokenCipher error = %v, want ErrNoTokenKey", err)
+	}
+}
+
+func TestTokenFromItem(t *testing.T) {
+	db := &DynamoDBStore{Keys: newTestKeyProvider(t)}
+	expiry := time.Date(2025, 3, 4, 12, 0, 0, 0, time.UTC)
+
+	tokenCipher, dataKey, err := db.newTokenCipher("token-1", "user-1")
+	if err != nil {
+		t.Fatalf("newTokenCipher: %v", err)
+	}
+	accessToken, _ := tokenCipher.encrypt("AccessToken", "access")
+	refreshToken, _ := tokenCipher.encrypt("RefreshToken", "refresh")
+
+	tests := []struct {
+		name    string
+		item    map[string]*dynamodb.AttributeValue
+		wantErr bool
+	}{
+		{
+			name: "encrypted",
+			item: map[string]*dynamodb.AttributeValue{
+				"ID":           {S: aws.String("token-1")},
+				"UserID":       {S: aws.String("user-1")},
+				"AccessToken":  {S: aws.String(accessToken)},
+				"RefreshToken": {S: aws.String(refreshToken)},
+				"DataKey":      {B: dataKey},
+				"Expiry":       {S: aws.String(expiry.Format(time.RFC3339))},
+			},
+		},
+		{
+			name: "plaintext without a data key",
+			item: map[string]*dynamodb.AttributeValue{
+				"ID":           {S: aws.String("token-1")},
+				"UserID":       {S: aws.String("user-1")},
+				"AccessToken":  {S: aws.String("access")},
+				"RefreshToken": {S: aws.String("refresh")},
+				"Expiry":       {S: aws.String(expiry.Format(time.RFC3339))},
+			},
+			wantErr: true,
+		},
+		{
+			name: "data key removed",
+			item: map[string]*dynamodb.AttributeValue{
+				"ID":           {S: aws.String("token-1")},
+				"UserID":       {S: aws.String("user-1")},
+				"AccessToken":  {S: aws.String(accessToken)},
+				"RefreshToken": {S: aws.String(refreshToken)},
+				"Expiry":       {S: aws.String(expiry.Format(time.RFC3339))},
+			},
+			wantErr: true,
+		},
+		{
+			name: "data key of another user",
+			item: map[string]*dynamodb.AttributeValue{
+				"ID":           {S: aws.String("token-1")},
+				"UserID":       {S: aws.String("user-2")},
+				"AccessToken":  {S: aws.String(accessToken)},
+				"RefreshToken": {S: aws.String(refreshToken)},
+				"DataKey":      {B: dataKey},
+				"Expiry":       {S: aws.String(expiry.Format(time.RFC3339))},
+			},
+			wantErr: true,
+		},
+		{
+			name: "access and refresh tokens swapped",
+			item: map[string]*dynamodb.AttributeValue{
+				"ID":           {S: aws.String("token-1")},
+				"UserID":       {S: aws.String("user-1")},
+				"AccessToken":  {S: aws.String(refreshToken)},
+				"RefreshToken": {S: aws.String(accessToken)},
+				"DataKey":      {B: dataKey},
+				"Expiry":       {S: aws.String(expiry.Format(time.RFC3339))},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := db.tokenFromItem(tt.item)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("tokenFromItem = %+v, want an error", token)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("tokenFromItem: %v", err)
+			}
+			if token.AccessToken != "access" || token.RefreshToken != "refresh" || !token.Expiry.Equal(expiry) {
+				t.Errorf("tokenFromItem = %+v", token)
+			}
+		})
+	}
+}
+
+func TestContextAADIsStable(t *testing.T) {
+	first := contextAAD(map[string]string{"table": "UserToken", "user_id": "user-1"})
+	second := contextAAD(map[string]string{"user_id": "user-1", "table": "UserToken"})
+	if !bytes.Equal(first, second) {
+		t.Errorf("contextAAD depends on map order: %q != %q", first, second)
+	}
+	// Lengths keep values containing separators from colliding
+	if bytes.Equal(contextAAD(map[string]string{"a": "b;c=d"}), contextAAD(map[string]string{"a": "b", "c": "d"})) {
+		t.Error("contextAAD collides for different contexts")
+	}
+}
+
+func TestKeyProviderFromEnv(t *testing.T) {
+	t.Setenv("TOKEN_KMS_KEY_ID", "")
+
+	t.Setenv("TOKEN_LOCAL_KEY", "")
+	if provider, err := keyProviderFromEnv(nil); provider != nil || err != nil {
+		t.Errorf("keyProviderFromEnv() = %v, %v, want no provider", provider, err)
+	}
+
+	t.Setenv("TOKEN_LOCAL_KEY", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, dataKeySize)))
+	if provider, err := keyProviderFromEnv(nil); err != nil {
+		t.Errorf("keyProviderFromEnv: %v", err)
+	} else if _, ok := provider.(*LocalKeyProvider); !ok {
+		t.Errorf("keyProviderFromEnv() = %T, want *LocalKeyProvider", provider)
+	}
+
+	t.Setenv("TOKEN_LOCAL_KEY", "not base64!")
+	if _, err := keyProviderFromEnv(nil); err == nil {
+		t.Error("keyProviderFromEnv accepted an invalid TOKEN_LOCAL_KEY")
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"lambda/types"
@@ -9,19 +10,37 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
-const TABLE_NAME = "Token"
+const (
+	TABLE_NAME         = "UserToken"
+	USER_ID_INDEX_NAME = "UserID-index"
+	tokenTTL           = 30 * 24 * time.Hour
+)
+
+// ErrTokenNotFound is returned when a user has no unrevoked token, e.g.
+// after disconnecting their account.
+var ErrTokenNotFound = errors.New("no token found")
 
 type DynamoDBStore struct {
-	DB *dynamodb.DynamoDB
+	DB dynamodbiface.DynamoDBAPI
+
+	// Keys encrypts the access and refresh tokens of stored tokens. Without
+	// it tokens cannot be stored or decrypted.
+	Keys KeyProvider
 }
 
 func NewDynamoDBStore() *DynamoDBStore {
 	dbSession := session.Must(session.NewSession())
 	db := dynamodb.New(dbSession)
+	keys, err := keyProviderFromEnv(dbSession)
+	if err != nil {
+		panic(err)
+	}
 	return &DynamoDBStore{
-		DB: db,
+		DB:   db,
+		Keys: keys,
 	}
 }
 
@@ -30,7 +49,21 @@ func (db *DynamoDBStore) StoreToken(token *types.Token) error {
 	tokenID := uuid.New().String()
 
 	// Calculate TTL (e.g., 30 days from now)
-	ttl := time.Now().Add(30 * 24 * time.Hour).Unix()
+	ttl := time.Now().Add(tokenTTL).Unix()
+
+	// Encrypt the tokens with a data key of their own
+	tokenCipher, dataKey, err := db.newTokenCipher(tokenID, token.UserID)
+	if err != nil {
+		return fmt.Errorf("error encrypting token: %w", err)
+	}
+	accessToken, err := tokenCipher.encrypt("AccessToken", token.AccessToken)
+	if err != nil {
+		return fmt.Errorf("error encrypting token: %w", err)
+	}
+	refreshToken, err := tokenCipher.encrypt("RefreshToken", token.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("error encrypting token: %w", err)
+	}
 
 	item := &dynamodb.PutItemInput{
 		TableName: aws.String(TABLE_NAME),
@@ -45,13 +78,19 @@ func (db *DynamoDBStore) StoreToken(token *types.Token) error {
 				S: aws.String(token.Email),
 			},
 			"AccessToken": {
-				S: aws.String(token.AccessToken),
+				S: aws.String(accessToken),
 			},
 			"TokenType": {
 				S: aws.String(token.TokenType),
 			},
 			"RefreshToken": {
-				S: aws.String(token.RefreshToken),
+				S: aws.String(refreshToken),
+			},
+			"DataKey": {
+				B: dataKey,
+			},
+			"Scope": {
+				S: aws.String(token.Scope),
 			},
 			"Expiry": {
 				S: aws.String(token.Expiry.Format(time.RFC3339)),
@@ -74,10 +113,278 @@ func (db *DynamoDBStore) StoreToken(token *types.Token) error {
 		},
 	}
 
-	_, err := db.DB.PutItem(item)
+	_, err = db.DB.PutItem(item)
 	if err != nil {
 		return fmt.Errorf("error inserting token into database: %w", err)
 	}
 
 	return nil
 }
+
+// LatestToken returns the most recently stored, unrevoked token of a user,
+// looked up through the UserID index sorted by CreatedAt.
+func (db *DynamoDBStore) LatestToken(userID string) (*types.Token, error) {
+	var latest map[string]*dynamodb.AttributeValue
+	err := db.DB.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(TABLE_NAME),
+		IndexName:              aws.String(USER_ID_INDEX_NAME),
+		KeyConditionExpression: aws.String("UserID = :uid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":uid": {S: aws.String(userID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			if !isRevoked(item) {
+				latest = item
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query token: %w", err)
+	}
+	if latest == nil {
+		return nil, fmt.Errorf("user %s: %w", userID, ErrTokenNotFound)
+	}
+	return db.tokenFromItem(latest)
+}
+
+// UserTokens returns every unrevoked token of a user, newest first.
+func (db *DynamoDBStore) UserTokens(userID string) ([]*types.Token, error) {
+	var items []map[string]*dynamodb.AttributeValue
+	err := db.DB.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(TABLE_NAME),
+		IndexName:              aws.String(USER_ID_INDEX_NAME),
+		KeyConditionExpression: aws.String("UserID = :uid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":uid": {S: aws.String(userID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			if !isRevoked(item) {
+				items = append(items, item)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tokens: %w", err)
+	}
+
+	tokens := make([]*types.Token, 0, len(items))
+	for _, item := range items {
+		token, err := db.tokenFromItem(item)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// UpdateToken saves a refreshed access token on the existing record instead
+// of storing a new one, and extends its TTL.
+func (db *DynamoDBStore) UpdateToken(token *types.Token) error {
+	if token.ID == "" {
+		return fmt.Errorf("token of user %s has no ID", token.UserID)
+	}
+
+	// Refreshed tokens get a new data key
+	tokenCipher, dataKey, err := db.newTokenCipher(token.ID, token.UserID)
+	if err != nil {
+		return fmt.Errorf("error encrypting token: %w", err)
+	}
+	accessToken, err := tokenCipher.encrypt("AccessToken", token.AccessToken)
+	if err != nil {
+		return fmt.Errorf("error encrypting token: %w", err)
+	}
+	refreshToken, err := tokenCipher.encrypt("RefreshToken", token.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("error encrypting token: %w", err)
+	}
+
+	now := time.Now()
+	_, err = db.DB.UpdateItem(&dynamodb.UpdateItemInput{
+		TableName: aws.String(TABLE_NAME),
+		Key: map[string]*dynamodb.AttributeValue{
+			"ID": {S: aws.String(token.ID)},
+		},
+		UpdateExpression: aws.String("SET AccessToken = :access, TokenType = :type, RefreshToken = :refresh, " +
+			"DataKey = :dataKey, Scope = :scope, Expiry = :expiry, ExpiresIn = :expiresIn, LastUsed = :now, #ttl = :ttl"),
+		ConditionExpression: aws.String("attribute_exists(ID)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#ttl": aws.String("TTL"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":access":    {S: aws.String(accessToken)},
+			":type":      {S: aws.String(token.TokenType)},
+			":refresh":   {S: aws.String(refreshToken)},
+			":dataKey":   {B: dataKey},
+			":scope":     {S: aws.String(token.Scope)},
+			":expiry":    {S: aws.String(token.Expiry.Format(time.RFC3339))},
+			":expiresIn": {N: aws.String(fmt.Sprintf("%d", int64(token.Expiry.Sub(now).Seconds())))},
+			":now":       {S: aws.String(now.Format(time.RFC3339))},
+			":ttl":       {N: aws.String(fmt.Sprintf("%d", now.Add(tokenTTL).Unix()))},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error updating token: %w", err)
+	}
+	return nil
+}
+
+// RevokeTokens marks every stored token of a user revoked and removes the
+// tokens themselves, keeping the record of when the user disconnected. It
+// returns how many tokens were revoked.
+func (db *DynamoDBStore) RevokeTokens(userID string) (int, error) {
+	var ids []string
+	err := db.DB.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(TABLE_NAME),
+		IndexName:              aws.String(USER_ID_INDEX_NAME),
+		KeyConditionExpression: aws.String("UserID = :uid"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":uid": {S: aws.String(userID)},
+		},
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			if !isRevoked(item) {
+				ids = append(ids, stringAttr(item, "ID"))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to query tokens: %w", err)
+	}
+
+	now := time.Now()
+	revoked := 0
+	for _, id := range ids {
+		_, err := db.DB.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName: aws.String(TABLE_NAME),
+			Key: map[string]*dynamodb.AttributeValue{
+				"ID": {S: aws.String(id)},
+			},
+			UpdateExpression:    aws.String("SET Revoked = :revoked, RevokedAt = :now REMOVE AccessToken, RefreshToken, DataKey"),
+			ConditionExpression: aws.String("attribute_exists(ID)"),
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":revoked": {BOOL: aws.Bool(true)},
+				":now":     {S: aws.String(now.Format(time.RFC3339))},
+			},
+		})
+		if isConditionalCheckFailed(err) {
+			// Removed by TTL in the meantime
+			continue
+		}
+		if err != nil {
+			return revoked, fmt.Errorf("error revoking token: %w", err)
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// LatestTokens returns the most recently stored, unrevoked token of every user.
+func (db *DynamoDBStore) LatestTokens() ([]*types.Token, error) {
+	// Only the selected tokens are decrypted, not every stored one
+	latest := map[string]map[string]*dynamodb.AttributeValue{}
+	latestCreated := map[string]time.Time{}
+	var parseErr error
+	err := db.DB.ScanPages(&dynamodb.ScanInput{
+		TableName: aws.String(TABLE_NAME),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			if isRevoked(item) {
+				continue
+			}
+			userID := stringAttr(item, "UserID")
+			var createdAt time.Time
+			if value := stringAttr(item, "CreatedAt"); value != "" {
+				var err error
+				if createdAt, err = time.Parse(time.RFC3339, value); err != nil {
+					parseErr = fmt.Errorf("failed to parse created at: %w", err)
+					return false
+				}
+			}
+			if current, ok := latestCreated[userID]; !ok || createdAt.After(current) {
+				latest[userID] = item
+				latestCreated[userID] = createdAt
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error scanning tokens: %w", err)
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	tokens := make([]*types.Token, 0, len(latest))
+	for _, item := range latest {
+		token, err := db.tokenFromItem(item)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// tokenFromItem converts a stored item back into a token, decrypting its
+// access and refresh tokens. Every stored token is encrypted, so a record
+// without a data key is rejected rather than read as plaintext.
+func (db *DynamoDBStore) tokenFromItem(item map[string]*dynamodb.AttributeValue) (*types.Token, error) {
+	token := &types.Token{
+		ID:           stringAttr(item, "ID"),
+		UserID:       stringAttr(item, "UserID"),
+		Email:        stringAttr(item, "Email"),
+		AccessToken:  stringAttr(item, "AccessToken"),
+		TokenType:    stringAttr(item, "TokenType"),
+		RefreshToken: stringAttr(item, "RefreshToken"),
+		Scope:        stringAttr(item, "Scope"),
+	}
+
+	var err error
+	if token.Expiry, err = time.Parse(time.RFC3339, stringAttr(item, "Expiry")); err != nil {
+		return nil, fmt.Errorf("failed to parse expiry: %w", err)
+	}
+	if createdAt := stringAttr(item, "CreatedAt"); createdAt != "" {
+		if token.CreatedAt, err = time.Parse(time.RFC3339, createdAt); err != nil {
+			return nil, fmt.Errorf("failed to parse created at: %w", err)
+		}
+	}
+	token.Revoked = isRevoked(item)
+
+	dataKey, ok := item["DataKey"]
+	if !ok || len(dataKey.B) == 0 {
+		return nil, fmt.Errorf("token %s has no data key", token.ID)
+	}
+	tokenCipher, err := db.openTokenCipher(token.ID, token.UserID, dataKey.B)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt token: %w", err)
+	}
+	if token.AccessToken, err = tokenCipher.decrypt("AccessToken", token.AccessToken); err != nil {
+		return nil, err
+	}
+	if token.RefreshToken, err = tokenCipher.decrypt("RefreshToken", token.RefreshToken); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func isRevoked(item map[string]*dynamodb.AttributeValue) bool {
+	revoked, ok := item["Revoked"]
+	return ok && revoked.BOOL != nil && *revoked.BOOL
+}
+
+// stringAttr returns a string attribute or "" when it is absent.
+func stringAttr(item map[string]*dynamodb.AttributeValue, name string) string {
+	if value, ok := item[name]; ok && value.S != nil {
+		return *value.S
+	}
+	return ""
+}
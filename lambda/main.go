@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"lambda/app"
 	"net/http"
+	"os"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -26,7 +29,14 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	lambda.Start(func(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// The same binary backs the scheduled expiry check
+	if os.Getenv("HANDLER_MODE") == "scheduler" {
+		lambda.Start(myApp.Scheduler.Run)
+		return
+	}
+
+	lambda.Start(func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 		if request.HTTPMethod == "OPTIONS" {
 			return events.APIGatewayProxyResponse{
 				StatusCode: 200,
@@ -40,11 +50,46 @@ func main() {
 			}, nil
 		}
 
+		// Calendar apps fetch the feed at /calendar/{feedToken}.ics
+		if request.HTTPMethod == http.MethodGet && strings.HasPrefix(request.Path, "/calendar/") {
+			return myApp.CalendarFeed.ServeFeed(request)
+		}
+
 		switch request.Path {
 		case "/login":
 			return myApp.LoginHandler.GetSpreedSheetAndRedirect(request)
 		case "/oauth2callback":
 			return myApp.CallbackHandler.OauthCallback(request)
+		case "/documents":
+			return myApp.TokenMiddleware.HandleRequest(ctx, request, myApp.Documents.ListDocuments)
+		case "/summary":
+			return myApp.TokenMiddleware.HandleRequest(ctx, request, myApp.Documents.Summary)
+		case "/settings/channels":
+			if request.HTTPMethod != http.MethodPut {
+				return events.APIGatewayProxyResponse{
+					StatusCode: http.StatusMethodNotAllowed,
+					Body:       "Method Not Allowed",
+					Headers: map[string]string{
+						"Allow":                            http.MethodPut,
+						"Access-Control-Allow-Origin":      "http://localhost:3000",
+						"Access-Control-Allow-Credentials": "true",
+					},
+				}, nil
+			}
+			return myApp.TokenMiddleware.HandleRequest(ctx, request, myApp.Settings.UpdateChannels)
+		case "/account":
+			if request.HTTPMethod != http.MethodDelete {
+				return events.APIGatewayProxyResponse{
+					StatusCode: http.StatusMethodNotAllowed,
+					Body:       "Method Not Allowed",
+					Headers: map[string]string{
+						"Allow":                            http.MethodDelete,
+						"Access-Control-Allow-Origin":      "http://localhost:3000",
+						"Access-Control-Allow-Credentials": "true",
+					},
+				}, nil
+			}
+			return myApp.TokenMiddleware.HandleSession(ctx, request, myApp.Account.DeleteAccount)
 		default:
 			return events.APIGatewayProxyResponse{
 				StatusCode: http.StatusBadRequest,
@@ -2,26 +2,25 @@ package middleware
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"golang.org/x/oauth2"
+	"lambda/api/auth"
 	"lambda/database"
 	"lambda/types"
 	"net/http"
-	"os"
-	"strings"
 	"time"
 )
 
 type TokenMiddleware struct {
-	DB *database.DynamoDBStore
+	DB       *database.DynamoDBStore
+	Auth     *auth.AuthConfig
+	Sessions *auth.SessionSigner
 }
 
-func NewTokenMiddleware() *TokenMiddleware {
-	return &TokenMiddleware{DB: database.NewDynamoDBStore()}
+func NewTokenMiddleware(dbStore *database.DynamoDBStore, authConfig *auth.AuthConfig, sessions *auth.SessionSigner) *TokenMiddleware {
+	return &TokenMiddleware{DB: dbStore, Auth: authConfig, Sessions: sessions}
 }
 
 func (tm *TokenMiddleware) HandleRequest(
@@ -31,18 +30,29 @@ func (tm *TokenMiddleware) HandleRequest(
 ) (events.APIGatewayProxyResponse, error) {
 	// function body
 
-	userID := getUserIDFromRequest(request)
-	if userID == "" {
+	now := time.Now()
+	session, err := tm.sessionFromRequest(request, now)
+	if err != nil {
 		return events.APIGatewayProxyResponse{
 			StatusCode: http.StatusUnauthorized,
-			Body:       "Unauthorized: Missing user identification",
+			Headers:    map[string]string{"Set-Cookie": auth.ClearSessionCookie()},
+			Body:       "Unauthorized: Invalid or expired session",
 		}, nil
 	}
-	token, err := tm.GetToken(userID)
-	if err != nil {
+	token, err := tm.GetToken(session.Subject)
+	if errors.Is(err, database.ErrTokenNotFound) {
+		// The user disconnected their account; the session is no longer valid
 		return events.APIGatewayProxyResponse{
 			StatusCode: http.StatusUnauthorized,
-			Body:       "Unauthorized: Cannot retrieve token",
+			Headers:    map[string]string{"Set-Cookie": auth.ClearSessionCookie()},
+			Body:       "Unauthorized: Token revoked",
+		}, nil
+	}
+	if err != nil {
+		fmt.Printf("failed to get token of user %s: %v\n", session.Subject, err)
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusInternalServerError,
+			Body:       "Internal server error: Cannot retrieve token",
 		}, nil
 	}
 	oauthToken := &oauth2.Token{
@@ -52,9 +62,9 @@ func (tm *TokenMiddleware) HandleRequest(
 		Expiry:       token.Expiry,
 	}
 
-	if oauthToken.Expiry.Before(time.Now().Add(5 * time.Minute)) {
+	if oauthToken.Expiry.Before(now.Add(5 * time.Minute)) {
 		// Token is expired or about to expire, refresh it
-		newOauthToken, err := refreshToken(oauthToken)
+		newOauthToken, err := refreshToken(ctx, tm.Auth, oauthToken)
 		if err != nil {
 			return events.APIGatewayProxyResponse{
 				StatusCode: http.StatusUnauthorized,
@@ -67,9 +77,13 @@ func (tm *TokenMiddleware) HandleRequest(
 		token.TokenType = newOauthToken.TokenType
 		token.RefreshToken = newOauthToken.RefreshToken
 		token.Expiry = newOauthToken.Expiry
+		if scope := auth.GrantedScope(newOauthToken); scope != "" {
+			token.Scope = scope
+		}
 
-		// Save the new token to database
-		if err := tm.DB.StoreToken(token); err != nil {
+		// Save the new token on the existing record
+		if err := tm.DB.UpdateToken(token); err != nil {
+			fmt.Printf("failed to save refreshed token: %v\n", err)
 			return events.APIGatewayProxyResponse{
 				StatusCode: http.StatusInternalServerError,
 				Body:       "Internal server error: Failed to save refreshed token",
@@ -88,48 +102,63 @@ func (tm *TokenMiddleware) HandleRequest(
 
 	ctxWithToken := context.WithValue(ctx, "oauth_token", oauthToken)
 	ctxWithUserToken := context.WithValue(ctxWithToken, "user_token", token)
+	ctxWithSession := context.WithValue(ctxWithUserToken, "session", session)
 
 	// Call the next handler with our new context
-	return handler(ctxWithUserToken, request)
-
+	response, err := handler(ctxWithSession, request)
+	if err != nil {
+		return response, err
+	}
+	return tm.rotateSession(response, session, now), nil
 }
-func (tm *TokenMiddleware) GetToken(userID string) (*types.Token, error) {
 
-	result, err := tm.DB.DB.Query(&dynamodb.QueryInput{
-		TableName:              aws.String(database.TABLE_NAME),
-		IndexName:              aws.String("UserID-index"),
-		KeyConditionExpression: aws.String("UserID = id"),
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":uid": {
-				S: aws.String(userID),
-			},
-		},
-		ScanIndexForward: aws.Bool(false),
-		Limit:            aws.Int64(1),
-	})
+// HandleSession only verifies the session, without requiring a stored token
+// that is still valid with Google. It serves requests that must work after
+// the user revoked access at Google, such as disconnecting the account.
+func (tm *TokenMiddleware) HandleSession(
+	ctx context.Context,
+	request events.APIGatewayProxyRequest,
+	handler func(ctx2 context.Context, proxyRequest events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error),
+) (events.APIGatewayProxyResponse, error) {
+	now := time.Now()
+	session, err := tm.sessionFromRequest(request, now)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query token %w", err)
-	}
-	if len(result.Items) == 0 {
-		return nil, fmt.Errorf("no token found for user %s", userID)
+		return events.APIGatewayProxyResponse{
+			StatusCode: http.StatusUnauthorized,
+			Headers:    map[string]string{"Set-Cookie": auth.ClearSessionCookie()},
+			Body:       "Unauthorized: Invalid or expired session",
+		}, nil
 	}
 
-	item := result.Items[0]
+	response, err := handler(context.WithValue(ctx, "session", session), request)
+	if err != nil {
+		return response, err
+	}
+	return tm.rotateSession(response, session, now), nil
+}
 
-	expiry, err := time.Parse(time.RFC3339, *item["Expiry"].S)
+// Helper function to replace sessions in use with a fresh token so active
+// users stay signed in. Responses setting the cookie themselves, e.g. to sign
+// the user out, are left alone.
+func (tm *TokenMiddleware) rotateSession(response events.APIGatewayProxyResponse, session *auth.SessionClaims, now time.Time) events.APIGatewayProxyResponse {
+	if !session.NeedsRotation(now) || response.Headers["Set-Cookie"] != "" {
+		return response
+	}
+	sessionToken, rotated, err := tm.Sessions.Rotate(session, now)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse expiry: %w", err)
+		fmt.Printf("session of user %s not rotated: %v\n", session.Subject, err)
+		return response
 	}
-	token := &types.Token{
-		UserID:       *item["UserID"].S,
-		Email:        *item["Email"].S,
-		AccessToken:  *item["AccessToken"].S,
-		TokenType:    *item["TokenType"].S,
-		RefreshToken: *item["RefreshToken"].S,
-		Expiry:       expiry,
+	headers := map[string]string{}
+	for name, value := range response.Headers {
+		headers[name] = value
 	}
-
-	return token, nil
+	headers["Set-Cookie"] = auth.SessionCookie(sessionToken, rotated)
+	response.Headers = headers
+	return response
+}
+func (tm *TokenMiddleware) GetToken(userID string) (*types.Token, error) {
+	return tm.DB.LatestToken(userID)
 }
 func isTokenValid(token *oauth2.Token) bool {
 	client := &http.Client{Timeout: 10 * time.Second}
@@ -142,78 +171,26 @@ func isTokenValid(token *oauth2.Token) bool {
 	defer resp.Body.Close()
 	return true
 }
-func refreshToken(oldToken *oauth2.Token) (*oauth2.Token, error) {
+
+// refreshToken exchanges the refresh token for a new access token using the
+// application's OAuth client.
+func refreshToken(ctx context.Context, authConfig *auth.AuthConfig, oldToken *oauth2.Token) (*oauth2.Token, error) {
 	if oldToken.RefreshToken == "" {
 		return nil, fmt.Errorf("no refresh token available")
 	}
 
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	refreshURL := "https://oauth2.googleapis.com/token"
-	reqBody := fmt.Sprintf(
-		"refresh_token=%s&client_id=%s&client_secret=%s&grant_type=refresh_token",
-		oldToken.RefreshToken,
-		getClientID(), // Implement this function to get your client ID
-		getClientSecret(),
-	)
-
-	req, err := http.NewRequest("POST", refreshURL, strings.NewReader(reqBody))
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("token refresh failed with status: %d", resp.StatusCode)
-	}
-
-	var tokenResp struct {
-		AccessToken string `json:"access_token"`
-		ExpiresIn   int    `json:"expires_in"`
-		TokenType   string `json:"token_type"`
-	}
-
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-		return nil, err
-	}
-
-	newToken := &oauth2.Token{
-		AccessToken:  tokenResp.AccessToken,
-		TokenType:    tokenResp.TokenType,
-		RefreshToken: oldToken.RefreshToken, // Keep the refresh token
-		Expiry:       time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second),
-	}
-
-	return newToken, nil
-}
-
-func getClientID() string {
-	// Return your OAuth client ID from environment or config
-	return os.Getenv("GOOGLE_CLIENT_ID")
+	// An expired token makes the token source refresh it
+	expired := *oldToken
+	expired.Expiry = time.Now().Add(-time.Minute)
+	return authConfig.ToOAuth2Config().TokenSource(ctx, &expired).Token()
 }
 
-func getClientSecret() string {
-	// Return your OAuth client secret from environment or config
-	return os.Getenv("GOOGLE_CLIENT_SECRET")
-}
-
-// Helper function to extract user ID from request
-func getUserIDFromRequest(req events.APIGatewayProxyRequest) string {
-	// Extract from headers, query parameters, or JWT token
-	// This is an example - customize to your auth system
-	if userID, ok := req.Headers["X-User-ID"]; ok {
-		return userID
+// Helper function to verify the session issued after sign in, sent as a
+// cookie or bearer token
+func (tm *TokenMiddleware) sessionFromRequest(req events.APIGatewayProxyRequest, now time.Time) (*auth.SessionClaims, error) {
+	sessionToken := auth.SessionTokenFromHeaders(req.Headers, req.MultiValueHeaders)
+	if sessionToken == "" {
+		return nil, auth.ErrInvalidSession
 	}
-
-	// Or extract from Authorization header if you're using JWTs
-	return ""
+	return tm.Sessions.Verify(sessionToken, now)
 }
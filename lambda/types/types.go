@@ -14,6 +14,7 @@ type Token struct {
 	RefreshToken string    `json:"refresh_token"` // Token used to get new access tokens
 	Expiry       time.Time `json:"expiry"`        // When the access token expires
 	ExpiresIn    int64     `json:"expires_in"`    // Seconds until expiration
+	Scope        string    `json:"scope"`         // Space separated scopes the user granted
 
 	// Additional metadata
 	CreatedAt time.Time `json:"created_at"` // When this token was first created
@@ -42,20 +43,196 @@ type UserInfo struct {
 	HD            string `json:"hd,omitempty"`     // Hosted domain for Google Workspace
 }
 
+// SheetSettings describes which part of a spreadsheet should be processed.
+type SheetSettings struct {
+	SpreadsheetID string   `json:"spreadsheet_id"`
+	SheetNames    []string `json:"sheet_names,omitempty"` // Tabs to read; empty means the first tab
+	Range         string   `json:"range,omitempty"`       // A1 range within each tab; empty means the entire used range
+
+	// Date handling
+	DateLayouts []string `json:"date_layouts,omitempty"` // Extra Go time layouts tried before the defaults
+	DayFirst    bool     `json:"day_first,omitempty"`    // Read 03/04/2025 as 3 April rather than March 4
+	Timezone    string   `json:"timezone,omitempty"`     // IANA zone the sheet's dates are in; empty means UTC
+
+	// Write-back
+	WriteBack bool `json:"write_back,omitempty"` // Write computed status, days left and last checked into the sheet
+	ColorRows bool `json:"color_rows,omitempty"` // Add conditional formatting for expired and expiring rows
+
+	// Status evaluation
+	WarningDays []int `json:"warning_days,omitempty"` // Days before expiry a document counts as expiring soon, e.g. 90, 30, 7
+}
+
+// NotificationSettings controls how the results of scheduled checks are
+// delivered for a spreadsheet.
+type NotificationSettings struct {
+	GmailLabel   string `json:"gmail_label,omitempty"`   // Label applied to notifications; empty means "DocExpiry"
+	EmailSummary bool   `json:"email_summary"`           // Email the document summary to the owner, at most weekly
+	Reminders    bool   `json:"reminders"`               // Email documents as they cross a reminder threshold
+	ReminderDays []int  `json:"reminder_days,omitempty"` // Days before expiry to remind at; 0 is the expiry day
+
+	// Distribution list copied on every summary and reminder
+	Recipients []string `json:"recipients,omitempty"`
+
+	// Chat and webhook channels notified in addition to email
+	Channels []NotificationChannel `json:"channels,omitempty"`
+}
+
+// Notification channel types.
+const (
+	ChannelSlack   = "slack"
+	ChannelTeams   = "teams"
+	ChannelWebhook = "webhook"
+)
+
+// NotificationChannel is an extra destination for summaries and reminders.
+type NotificationChannel struct {
+	Type   string `json:"type"`             // ChannelSlack, ChannelTeams or ChannelWebhook
+	URL    string `json:"url"`              // Incoming webhook URL
+	Secret string `json:"secret,omitempty"` // Key used to sign generic webhook requests
+}
+
+// CalendarSettings controls the Google Calendar events created for a
+// spreadsheet's documents.
+type CalendarSettings struct {
+	Enabled      bool   `json:"enabled"`
+	CalendarID   string `json:"calendar_id,omitempty"`   // Calendar to write to; empty means the user's primary calendar
+	ReminderDays []int  `json:"reminder_days,omitempty"` // Popup reminders, in days before the expiry date
+}
+
+// EmailThread identifies a Gmail thread so later messages can reply to it.
+type EmailThread struct {
+	ThreadID  string `json:"thread_id,omitempty"`
+	MessageID string `json:"message_id,omitempty"` // Message-ID header of the latest message
+}
+
+// Spreadsheet is a spreadsheet a user registered for scheduled checks.
+type Spreadsheet struct {
+	UserID string `json:"user_id"`
+	SheetSettings
+	Notifications NotificationSettings `json:"notifications"`
+	Calendar      CalendarSettings     `json:"calendar"`
+	SummaryThread EmailThread          `json:"summary_thread"` // Gmail thread the summaries are kept in
+	CreatedAt     time.Time            `json:"created_at"`
+	UpdatedAt     time.Time            `json:"updated_at"`
+}
+
+// Computed document statuses.
+const (
+	StatusExpired      = "Expired"
+	StatusExpiringSoon = "Expiring Soon"
+	StatusValid        = "Valid"
+	StatusUnknown      = "Unknown"
+)
+
 type Document struct {
-	DocumentName string
-	IssueDate    time.Time
-	ExpiryDate   time.Time
-	Duration     time.Duration // Note: Duration is unusual as time.Time, typically it would be time.Duration
-	Status       string
+	ID           string        `json:"id"` // Stable identity derived from the tab and document name
+	DocumentName string        `json:"document_name"`
+	IssueDate    time.Time     `json:"issue_date"`
+	ExpiryDate   time.Time     `json:"expiry_date"`
+	Duration     time.Duration `json:"duration"` // Note: Duration is unusual as time.Time, typically it would be time.Duration
+	Status       string        `json:"status"`   // Computed from ExpiryDate by the status engine
+
+	// Status evaluation
+	SheetStatus    string `json:"sheet_status"`              // Status as typed in the sheet
+	DaysRemaining  int    `json:"days_remaining"`            // Days until expiry; negative once expired
+	WarningWindow  int    `json:"warning_window,omitempty"`  // Smallest warning window the document falls in
+	StatusMismatch bool   `json:"status_mismatch,omitempty"` // Sheet status disagrees with the computed one
+
+	// Where the document was read from
+	Sheet string `json:"sheet"` // Tab title
+	Row   int    `json:"row"`   // 1-based sheet row number
+
+	// People reminded about the document, read from the Owner and Notify
+	// columns. Empty means the spreadsheet owner.
+	Recipients []string `json:"recipients,omitempty"`
+}
+
+// RowIssue describes a sheet row that could not be turned into a document.
+type RowIssue struct {
+	Sheet  string `json:"sheet"`            // Tab title
+	Row    int    `json:"row"`              // 1-based sheet row number
+	Column string `json:"column,omitempty"` // Header of the offending column
+	Value  string `json:"value,omitempty"`  // Cell value as read from the sheet
+	Reason string `json:"reason"`           // Why the row was skipped or partly ignored
+}
+
+// SheetTab records the layout of a processed tab so results can be written
+// back next to the documents they belong to.
+type SheetTab struct {
+	Title       string   `json:"title"`
+	SheetID     int64    `json:"sheet_id"`
+	HeaderRow   int      `json:"header_row"`   // 1-based sheet row number of the headers
+	FirstColumn int      `json:"first_column"` // 0-based column index of Headers[0]
+	ColumnCount int64    `json:"column_count"` // Columns in the tab's grid
+	Headers     []string `json:"headers"`
+}
+
+// SheetResult is the outcome of processing a spreadsheet.
+type SheetResult struct {
+	Title     string      `json:"title"` // Spreadsheet title
+	Documents []*Document `json:"documents"`
+	Issues    []RowIssue  `json:"issues"`
+	Tabs      []*SheetTab `json:"-"`
+}
+
+// CheckResult is the outcome of the last check of a spreadsheet, kept so
+// the dashboard can be served without reading the sheet again.
+type CheckResult struct {
+	UserID        string `json:"user_id"`
+	SpreadsheetID string `json:"spreadsheet_id"`
+	SheetResult
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// StoredDocument is a document as last seen in its spreadsheet.
+type StoredDocument struct {
+	UserID        string `json:"user_id"`
+	SpreadsheetID string `json:"spreadsheet_id"`
+	Document
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Deleted   bool      `json:"deleted,omitempty"` // No longer found in the spreadsheet
+}
+
+// Kinds of document changes.
+const (
+	ChangeAdded         = "added"
+	ChangeRenewed       = "renewed"        // Expiry date moved later
+	ChangeExpiryChanged = "expiry_changed" // Expiry date moved earlier
+	ChangeStatusChanged = "status_changed"
+	ChangeDeleted       = "deleted" // Row deleted or no longer readable
+	ChangeRestored      = "restored"
+)
+
+// DocumentChange is one entry in a document's history.
+type DocumentChange struct {
+	UserID        string    `json:"user_id"`
+	SpreadsheetID string    `json:"spreadsheet_id"`
+	DocumentID    string    `json:"document_id"`
+	DocumentName  string    `json:"document_name"`
+	Change        string    `json:"change"`
+	OldValue      string    `json:"old_value,omitempty"`
+	NewValue      string    `json:"new_value,omitempty"`
+	ChangedAt     time.Time `json:"changed_at"`
+}
+
+// OAuthSession is the server side record of a sign in started at /login,
+// consumed when Google redirects back to /oauth2callback.
+type OAuthSession struct {
+	Nonce        string    `json:"nonce"`
+	StateHash    string    `json:"state_hash"`    // SHA-256 of the state parameter sent to Google
+	CodeVerifier string    `json:"code_verifier"` // PKCE verifier presented when exchanging the code
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    int64     `json:"expires_at"` // Unix time; removed by DynamoDB TTL
 }
 
-func NewDoc(documentName string, issueDate time.Time, expiryDate time.Time, duration time.Duration, status string) *Document {
+func NewDoc(documentName string, issueDate time.Time, expiryDate time.Time, duration time.Duration, sheetStatus string) *Document {
 	return &Document{
 		DocumentName: documentName,
 		IssueDate:    issueDate,
 		ExpiryDate:   expiryDate,
 		Duration:     duration,
-		Status:       status,
+		Status:       StatusUnknown,
+		SheetStatus:  sheetStatus,
 	}
 }